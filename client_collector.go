@@ -0,0 +1,187 @@
+package edgemax_exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+// A ClientCollector is a Prometheus collector for metrics regarding the
+// health of an edgemax.Client. It implements edgemax.Observer, and must be
+// passed to edgemax.NewClient using edgemax.WithObserver.
+type ClientCollector struct {
+	up                prometheus.Gauge
+	loginFailures     prometheus.Counter
+	receivedBytes     prometheus.Counter
+	messages          *prometheus.CounterVec
+	lastMessage       *prometheus.GaugeVec
+	reconnects        prometheus.Counter
+	reconnectAttempts prometheus.Counter
+	reconnectFailures prometheus.Counter
+	droppedMessages   *prometheus.CounterVec
+	decodeErrors      *prometheus.CounterVec
+}
+
+// Verify that the ClientCollector implements the prometheus.Collector and
+// edgemax.Observer interfaces.
+var (
+	_ prometheus.Collector = &ClientCollector{}
+	_ edgemax.Observer     = &ClientCollector{}
+)
+
+// NewClientCollector creates a new ClientCollector.
+func NewClientCollector() *ClientCollector {
+	return &ClientCollector{
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "up",
+			Help:      "Whether the stats feed of the EdgeMAX device is available",
+		}),
+		loginFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "login",
+			Name:      "failures_total",
+			Help:      "Number of failed attempts to log in to the EdgeMAX device",
+		}),
+		receivedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "stream",
+			Name:      "bytes_total",
+			Help:      "Number of bytes received on the stats websocket",
+		}),
+		messages: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "stream",
+				Name:      "messages_total",
+				Help:      "Number of stats messages received, partitioned by stream",
+			},
+			[]string{"stream"},
+		),
+		lastMessage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "stream",
+				Name:      "last_message_timestamp_seconds",
+				Help:      "Time the latest stats message was received, partitioned by stream",
+			},
+			[]string{"stream"},
+		),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "reconnects_total",
+			Help:      "Number of times the stats websocket was reconnected",
+		}),
+		reconnectAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "reconnect_attempts_total",
+			Help:      "Number of attempts to reconnect the stats websocket",
+		}),
+		reconnectFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "reconnect_failures_total",
+			Help:      "Number of failed attempts to reconnect the stats websocket",
+		}),
+		droppedMessages: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "stream",
+				Name:      "dropped_messages_total",
+				Help:      "Number of stats messages dropped because a consumer did not keep up, partitioned by stream",
+			},
+			[]string{"stream"},
+		),
+		decodeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "exporter",
+				Name:      "decode_errors_total",
+				Help:      "Number of stats messages rejected because they could not be decoded, partitioned by stream and malformed field",
+			},
+			[]string{"stream", "field"},
+		),
+	}
+}
+
+// Up implements edgemax.Observer.
+func (c *ClientCollector) Up(up bool) {
+	if up {
+		c.up.Set(1)
+	} else {
+		c.up.Set(0)
+	}
+}
+
+// Login implements edgemax.Observer.
+func (c *ClientCollector) Login(err error) {
+	if err != nil {
+		c.loginFailures.Inc()
+	}
+}
+
+// Received implements edgemax.Observer.
+func (c *ClientCollector) Received(size int) {
+	c.receivedBytes.Add(float64(size))
+}
+
+// Message implements edgemax.Observer.
+func (c *ClientCollector) Message(stream string) {
+	c.messages.WithLabelValues(stream).Inc()
+	c.lastMessage.WithLabelValues(stream).SetToCurrentTime()
+}
+
+// Reconnect implements edgemax.Observer.
+func (c *ClientCollector) Reconnect(err error) {
+	c.reconnectAttempts.Inc()
+	if err != nil {
+		c.reconnectFailures.Inc()
+		return
+	}
+	c.reconnects.Inc()
+}
+
+// Dropped implements edgemax.Observer.
+func (c *ClientCollector) Dropped(stream string) {
+	c.droppedMessages.WithLabelValues(stream).Inc()
+}
+
+// Rejected implements edgemax.Observer.
+func (c *ClientCollector) Rejected(err *edgemax.DecodeError) {
+	c.decodeErrors.WithLabelValues(err.Stream, err.Field).Inc()
+}
+
+// collectors contains a list of collectors which are collected each time
+// the exporter is scraped. This list must be kept in sync with the collectors
+// in ClientCollector.
+func (c *ClientCollector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.up,
+		c.loginFailures,
+		c.receivedBytes,
+		c.messages,
+		c.lastMessage,
+		c.reconnects,
+		c.reconnectAttempts,
+		c.reconnectFailures,
+		c.droppedMessages,
+		c.decodeErrors,
+	}
+}
+
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *ClientCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+// Collect sends the metric values for each metric pertaining to the
+// client over to the provided prometheus Metric channel.
+func (c *ClientCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
@@ -1,78 +1,395 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vaga/edgemax_exporter"
+	"github.com/vaga/edgemax_exporter/config"
 	"github.com/vaga/edgemax_exporter/edgemax"
+	"github.com/vaga/edgemax_exporter/web"
 )
 
+// shutdownTimeout is the time allowed for in-flight requests to complete
+// when the exporter stops.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the exporter until it receives SIGINT or SIGTERM. Errors are
+// returned rather than fatal, so that sessions opened so far are closed.
+func run() error {
 	var (
+		configFile = flag.String("config.file", "", "[optional] path to a YAML configuration file defining devices scraped using the probe endpoint, and web settings")
+
 		listenAddress = flag.String("web.listen-address", ":9132", "host:port for EdgeMAX exporter")
+		webConfig     = flag.String("web.config.file", "", "[optional] path to a configuration file enabling TLS or basic authentication, in the Prometheus exporter-toolkit format")
 		metricsPath   = flag.String("web.telemetry-path", "/metrics", "URL path for surfacing collected metrics")
+		probePath     = flag.String("web.probe-path", "/probe", "URL path for surfacing metrics of the device selected by the 'target' parameter")
+
+		address      = flag.String("edgemax.address", "", "address of EdgeMAX Controller API")
+		username     = flag.String("edgemax.username", "", "username for authentication against EdgeMAX Controller API (or $EDGEMAX_USERNAME)")
+		password     = flag.String("edgemax.password", "", "password for authentication against EdgeMAX Controller API (or $EDGEMAX_PASSWORD)")
+		passwordFile = flag.String("edgemax.password-file", "", "file containing the password for authentication against EdgeMAX Controller API, read again when logging in again (or $EDGEMAX_PASSWORD_FILE)")
+		insecure     = flag.Bool("edgemax.insecure", false, "[optional] do not verify TLS certificate for EdgeMAX Controller API (warning: please use carefully)")
+		caFile       = flag.String("edgemax.tls.ca-file", "", "[optional] file containing the certificate authorities trusted to verify EdgeMAX Controller API certificates")
+		fingerprint  = flag.String("edgemax.tls.fingerprint", "", "[optional] SHA-256 fingerprint of the EdgeMAX Controller API certificate, trusted instead of a certificate authority (e.g. for self-signed certificates)")
+		proxyURL     = flag.String("edgemax.proxy-url", "", "[optional] URL of the HTTP CONNECT ('http://') or SOCKS5 ('socks5://') proxy used to reach EdgeMAX Controller API, without credentials, instead of the proxy from the environment")
+		timeout      = flag.Duration("edgemax.timeout", 5*time.Second, "[optional] timeout for EdgeMAX Controller API requests")
+		seriesTTL    = flag.Duration("edgemax.series-ttl", 5*time.Minute, "[optional] duration after which interfaces and DPI clients no longer reported by the device are removed")
 
-		address  = flag.String("edgemax.address", "", "address of EdgeMAX Controller API")
-		username = flag.String("edgemax.username", "", "username for authentication against EdgeMAX Controller API")
-		password = flag.String("edgemax.password", "", "password for authentication against EdgeMAX Controller API")
-		insecure = flag.Bool("edgemax.insecure", false, "[optional] do not verify TLS certificate for EdgeMAX Controller API (warning: please use carefully)")
-		timeout  = flag.Duration("edgemax.timeout", 5*time.Second, "[optional] timeout for EdgeMAX Controller API requests")
+		legacyGauges = flag.Bool("compat.legacy-byte-gauges", false, "[optional] also export byte counts as the gauges used before 'edgemax_interface_receive_bytes_total' and similar counters (deprecated)")
+
+		targets targetsFlag
 	)
+	flag.Var(&targets, "probe.target", "[optional] device which can be scraped using the probe endpoint, as 'name=https://address' (repeatable), logged in to with '-edgemax.username' and $EDGEMAX_PASSWORD or '-edgemax.password-file'")
 	flag.Parse()
 
-	if *address == "" {
-		log.Fatalln("address of EdgeMAX Controller API must be specified with '-edgemax.address' flag")
+	// Signals are handled from the start, so that the sessions opened
+	// while starting are closed as well.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	// Secrets may be passed in the environment rather than as flags, so
+	// that they do not show up in the process list.
+	for _, v := range []struct {
+		flag *string
+		env  string
+	}{
+		{flag: username, env: "EDGEMAX_USERNAME"},
+		{flag: password, env: "EDGEMAX_PASSWORD"},
+		{flag: passwordFile, env: "EDGEMAX_PASSWORD_FILE"},
+	} {
+		if *v.flag == "" {
+			*v.flag = os.Getenv(v.env)
+		}
 	}
-	if *username == "" {
-		log.Fatal("username to authenticate to EdgeMAX Controller API must be specified with '-edgemax.username' flag")
+	if *password != "" && *passwordFile != "" {
+		return errors.New("only one of '-edgemax.password' and '-edgemax.password-file' flags may be specified")
 	}
-	if *password == "" {
-		log.Fatal("password to authenticate to EdgeMAX Controller API must be specified with '-edgemax.password' flag")
+
+	// The password file is read again each time the exporter logs in, so
+	// that rotated credentials are picked up.
+	var credentials edgemax.CredentialsProvider
+	if *passwordFile != "" {
+		credentials = edgemax.PasswordFile(*username, *passwordFile)
 	}
 
-	c, err := edgemax.NewClient(*address, newHTTPClient(*timeout, *insecure))
+	flagsTLS, err := config.TLS{
+		CAFile:             *caFile,
+		Fingerprint:        *fingerprint,
+		InsecureSkipVerify: *insecure,
+	}.Config()
 	if err != nil {
-		log.Fatalf("cannot create EdgeMAX Controller client: %v", err)
+		return fmt.Errorf("invalid '-edgemax.tls.*' flags: %v", err)
 	}
-	if err := c.Login(*username, *password); err != nil {
-		log.Fatalln("failed to authenticate to EdgeMAX Controller: %v", err)
+	flagsProxy, err := parseProxyFlag(*proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid '-edgemax.proxy-url' flag: %v", err)
 	}
 
-	e, done, err := edgemax_exporter.New(c)
+	ts := make([]edgemax_exporter.Target, 0, len(targets))
+	for _, v := range targets {
+		t, err := parseTarget(v)
+		if err != nil {
+			return err
+		}
+		t.Username, t.Password, t.Credentials = *username, *password, credentials
+		if t.Username == "" || (t.Password == "" && t.Credentials == nil) {
+			return fmt.Errorf("credentials for target %q must be specified with '-edgemax.username' and $EDGEMAX_PASSWORD or '-edgemax.password-file'", t.Name)
+		}
+		t.Client = newHTTPClient(*timeout, flagsTLS, flagsProxy)
+		ts = append(ts, t)
+	}
+
+	if *address == "" && len(ts) == 0 && *configFile == "" {
+		return errors.New("address of EdgeMAX Controller API must be specified with '-edgemax.address', '-probe.target' or '-config.file' flags")
+	}
+
+	options := []edgemax_exporter.Option{
+		edgemax_exporter.WithSeriesTTL(*seriesTTL),
+		edgemax_exporter.WithLegacyGauges(*legacyGauges),
+	}
+
+	p, err := edgemax_exporter.NewProber(ts, options...)
 	if err != nil {
-		log.Fatalln("cannot create EdgeMAX Controller exporter: %v", err)
+		return fmt.Errorf("cannot create EdgeMAX prober: %v", err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			log.Printf("EdgeMAX prober stopped with error: %v", err)
+		}
+	}()
+
+	if *configFile != "" {
+		r := newReloader(*configFile, *timeout, p)
+		cfg, err := r.reload()
+		if err != nil {
+			return fmt.Errorf("cannot load configuration: %v", err)
+		}
+
+		// Web settings of the file replace the defaults of the flags,
+		// but not flags set on the command line.
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for _, v := range []struct {
+			name  string
+			flag  *string
+			value string
+		}{
+			{name: "web.listen-address", flag: listenAddress, value: cfg.Web.ListenAddress},
+			{name: "web.telemetry-path", flag: metricsPath, value: cfg.Web.TelemetryPath},
+			{name: "web.probe-path", flag: probePath, value: cfg.Web.ProbePath},
+			{name: "web.config.file", flag: webConfig, value: cfg.Web.ConfigFile},
+		} {
+			if v.value != "" && !set[v.name] {
+				*v.flag = v.value
+			}
+		}
+
+		prometheus.MustRegister(r)
+		http.Handle("/-/reload", r)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for range hup {
+				if _, err := r.reload(); err != nil {
+					log.Printf("cannot reload configuration: %v", err)
+					continue
+				}
+				log.Println("reloaded configuration")
+			}
+		}()
+	}
+
+	if targets := p.Targets(); len(targets) > 0 || *configFile != "" {
+		http.Handle(*probePath, p)
+		log.Printf("Serving probes on %q for targets %s", *probePath, strings.Join(targets, ", "))
+	}
+
+	if *address != "" {
+		e, c, err := startExporter(*address, *username, *password, credentials, newHTTPClient(*timeout, flagsTLS, flagsProxy), options)
+		if err != nil {
+			return err
+		}
+		defer stopExporter(e, c)
 	}
-	defer done()
 
-	prometheus.MustRegister(e)
 	http.Handle(*metricsPath, prometheus.Handler())
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, *metricsPath, http.StatusMovedPermanently)
 	})
 
-	log.Printf("Starting EdgeMAX exporter on %q for device at %q", *listenAddress, *address)
+	// Certificates are read again on each TLS handshake, so that they can
+	// be renewed without a restart.
+	if err := web.Validate(*webConfig); err != nil {
+		return fmt.Errorf("invalid web configuration: %v", err)
+	}
+
+	log.Printf("Starting EdgeMAX exporter on %q", *listenAddress)
+
+	server := &http.Server{Handler: http.DefaultServeMux}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- web.ListenAndServe(server, *listenAddress, *webConfig)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("cannot start EdgeMAX exporter: %v", err)
+	case sig := <-sigCh:
+		log.Printf("Received %s, shutting down", sig)
+	}
 
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
-		log.Printf("cannot start EdgeMAX exporter: %s", err)
-		return
+	// Drain in-flight scrapes before the sessions they read from are
+	// closed by the deferred calls.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("could not drain HTTP server: %v", err)
 	}
+	return nil
 }
 
-func newHTTPClient(timeout time.Duration, insecure bool) *http.Client {
-	c := &http.Client{Timeout: timeout}
+// startExporter logs in to the device specified by the '-edgemax.*' flags,
+// and registers its metrics on the default registry. If credentials is not
+// nil, it provides the password instead.
+func startExporter(address, username, password string, credentials edgemax.CredentialsProvider, hc *http.Client, options []edgemax_exporter.Option) (*edgemax_exporter.Exporter, *edgemax.Client, error) {
+	if username == "" {
+		return nil, nil, errors.New("username to authenticate to EdgeMAX Controller API must be specified with '-edgemax.username' flag")
+	}
+
+	cc := edgemax_exporter.NewClientCollector()
+	clientOptions := []edgemax.Option{edgemax.WithObserver(cc)}
 
-	if insecure {
-		c.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+	if credentials != nil {
+		var err error
+		if username, password, err = credentials(); err != nil {
+			return nil, nil, fmt.Errorf("cannot read EdgeMAX Controller password: %v", err)
 		}
+		clientOptions = append(clientOptions, edgemax.WithCredentialsProvider(credentials))
+	}
+	if password == "" {
+		return nil, nil, errors.New("password to authenticate to EdgeMAX Controller API must be specified with '-edgemax.password' or '-edgemax.password-file' flags")
+	}
+
+	c, err := edgemax.NewClient(address, hc, clientOptions...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create EdgeMAX Controller client: %v", err)
+	}
+	if err := c.Login(username, password); err != nil {
+		switch {
+		case errors.Is(err, edgemax.ErrInvalidCredentials):
+			return nil, nil, fmt.Errorf("failed to authenticate to EdgeMAX Controller: check '-edgemax.username' and '-edgemax.password': %v", err)
+		case errors.Is(err, edgemax.ErrTooManyAttempts):
+			return nil, nil, fmt.Errorf("failed to authenticate to EdgeMAX Controller: account is locked out, retry later: %v", err)
+		case errors.Is(err, edgemax.ErrNotEdgeOS):
+			return nil, nil, fmt.Errorf("failed to authenticate to EdgeMAX Controller: check '-edgemax.address' points to an EdgeOS device: %v", err)
+		default:
+			return nil, nil, fmt.Errorf("failed to authenticate to EdgeMAX Controller: %v", err)
+		}
+	}
+
+	e, err := edgemax_exporter.New(c, options...)
+	if err != nil {
+		c.Logout()
+		return nil, nil, fmt.Errorf("cannot create EdgeMAX Controller exporter: %v", err)
+	}
+
+	prometheus.MustRegister(e, cc)
+	log.Printf("Exporting metrics of device at %q", address)
+	return e, c, nil
+}
+
+// stopExporter closes the session of an Exporter, and logs out of its
+// device.
+func stopExporter(e *edgemax_exporter.Exporter, c *edgemax.Client) {
+	if err := e.Close(); err != nil {
+		log.Printf("EdgeMAX exporter stopped with error: %v", err)
+	}
+	if err := c.Logout(); err != nil {
+		log.Printf("could not log out of EdgeMAX Controller: %v", err)
+	}
+}
+
+// newTarget creates the probe target of a configured device. timeout is
+// used if the device does not specify one.
+func newTarget(d config.Device, timeout time.Duration) (edgemax_exporter.Target, error) {
+	tc, err := d.TLS.Config()
+	if err != nil {
+		return edgemax_exporter.Target{}, err
+	}
+	proxy, err := config.ParseProxyURL(string(d.ProxyURL))
+	if err != nil {
+		return edgemax_exporter.Target{}, err
+	}
+	if d.Timeout > 0 {
+		timeout = d.Timeout
+	}
+
+	t := edgemax_exporter.Target{
+		Name:       d.Name,
+		Address:    d.Address,
+		Username:   d.Username,
+		Password:   string(d.Password),
+		Client:     newHTTPClient(timeout, tc, proxy),
+		Collectors: d.Collectors,
+		Labels:     d.Labels,
+	}
+	if d.PasswordFile != "" {
+		t.Credentials = edgemax.PasswordFile(d.Username, d.PasswordFile)
+	}
+
+	return t, nil
+}
+
+// newHTTPClient creates an HTTP client for the EdgeMAX API, using its own
+// transport so that the TLS configuration and proxy can be shared with the
+// websocket. If proxy is nil, the proxy from the environment is used.
+func newHTTPClient(timeout time.Duration, tc *tls.Config, proxy *url.URL) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tc
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: t,
+	}
+}
+
+// A targetsFlag is a flag.Value which accumulates probe targets specified
+// as 'name=address'. Values are only parsed by parseTarget, since the flag
+// package prints invalid values, and an address may mistakenly contain a
+// password.
+type targetsFlag []string
+
+func (f *targetsFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, v := range *f {
+		names = append(names, strings.SplitN(v, "=", 2)[0])
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *targetsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// parseProxyFlag parses the URL of the proxy specified by flags. As for
+// probe targets, credentials are rejected since they would be visible to
+// other users of the host.
+func parseProxyFlag(s string) (*url.URL, error) {
+	u, err := config.ParseProxyURL(s)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil && u.User != nil {
+		return nil, errors.New("proxy credentials must be specified with $HTTPS_PROXY or 'proxy_url' in the configuration file")
+	}
+	return u, nil
+}
+
+// parseTarget parses a probe target specified as 'name=address'. Passwords
+// in command-line arguments are visible to other users of the host, so
+// credentials in the address are rejected. Errors do not include the
+// address.
+func parseTarget(v string) (edgemax_exporter.Target, error) {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return edgemax_exporter.Target{}, fmt.Errorf("invalid target %q: expected 'name=address'", kv[0])
+	}
+
+	u, err := url.Parse(kv[1])
+	if err != nil {
+		return edgemax_exporter.Target{}, fmt.Errorf("invalid address for target %q", kv[0])
+	}
+
+	if u.User != nil {
+		return edgemax_exporter.Target{}, fmt.Errorf("invalid address for target %q: credentials must be specified with '-edgemax.username' and $EDGEMAX_PASSWORD or '-edgemax.password-file'", kv[0])
 	}
 
-	return c
+	return edgemax_exporter.Target{Name: kv[0], Address: u.String()}, nil
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vaga/edgemax_exporter"
+	"github.com/vaga/edgemax_exporter/config"
+)
+
+// A reloader applies the devices of the configuration file to a Prober.
+// Only devices which were added, removed or changed are started, stopped or
+// reconnected, so that the sessions of other devices are kept.
+type reloader struct {
+	file    string
+	timeout time.Duration
+	prober  *edgemax_exporter.Prober
+
+	successful prometheus.Gauge
+	timestamp  prometheus.Gauge
+
+	mu      sync.Mutex
+	devices map[string]config.Device
+}
+
+// newReloader creates a reloader for the configuration file, whose devices
+// use timeout unless they specify one.
+func newReloader(file string, timeout time.Duration, p *edgemax_exporter.Prober) *reloader {
+	return &reloader{
+		file:    file,
+		timeout: timeout,
+		prober:  p,
+
+		successful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "edgemax",
+			Subsystem: "exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful",
+		}),
+		timestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "edgemax",
+			Subsystem: "exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Time of the last successful configuration reload",
+		}),
+
+		devices: make(map[string]config.Device),
+	}
+}
+
+// reload loads the configuration file, and applies its devices. Web settings
+// are only applied on startup.
+func (r *reloader) reload() (*config.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.apply()
+	if err != nil {
+		r.successful.Set(0)
+		return nil, err
+	}
+
+	r.successful.Set(1)
+	r.timestamp.SetToCurrentTime()
+	return cfg, nil
+}
+
+// apply implements reload. The caller must hold mu.
+func (r *reloader) apply() (*config.Config, error) {
+	cfg, err := config.Load(r.file)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]config.Device, len(cfg.Devices))
+	for _, d := range cfg.Devices {
+		devices[d.Name] = d
+	}
+
+	// Create the targets of new and changed devices first, and replace
+	// them at once, so that an invalid device leaves the running ones
+	// untouched.
+	var (
+		removed []string
+		added   []edgemax_exporter.Target
+	)
+	for name, d := range r.devices {
+		if nd, ok := devices[name]; !ok || !reflect.DeepEqual(d, nd) {
+			removed = append(removed, name)
+		}
+	}
+	for name, d := range devices {
+		if od, ok := r.devices[name]; ok && reflect.DeepEqual(od, d) {
+			continue
+		}
+
+		t, err := newTarget(d, r.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("cannot configure device %q: %v", name, err)
+		}
+		added = append(added, t)
+	}
+	sort.Strings(removed)
+	sort.Slice(added, func(i, j int) bool { return added[i].Name < added[j].Name })
+
+	if err := r.prober.Replace(removed, added); err != nil {
+		return nil, err
+	}
+
+	for _, name := range removed {
+		delete(r.devices, name)
+		log.Printf("stopped device %q", name)
+	}
+	for _, t := range added {
+		r.devices[t.Name] = devices[t.Name]
+		log.Printf("started device %q", t.Name)
+	}
+
+	return cfg, nil
+}
+
+// ServeHTTP implements http.Handler, reloading the configuration on POST
+// requests.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := r.reload(); err != nil {
+		log.Printf("cannot reload configuration: %v", err)
+		http.Error(w, fmt.Sprintf("cannot reload configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Println("reloaded configuration")
+}
+
+// Describe implements prometheus.Collector.
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.successful.Describe(ch)
+	r.timestamp.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	r.successful.Collect(ch)
+	r.timestamp.Collect(ch)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vaga/edgemax_exporter"
+)
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edgemax_exporter")
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "edgemax.yml")
+
+	// A target specified by flags, which devices must not replace.
+	p, err := edgemax_exporter.NewProber([]edgemax_exporter.Target{{Name: "flag", Address: "https://192.0.2.254"}})
+	if err != nil {
+		t.Fatalf("failed to create prober: %v", err)
+	}
+	defer p.Close()
+	r := newReloader(file, time.Second, p)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var tests = []struct {
+		desc    string
+		devices []string
+		targets []string
+		logs    []string
+		err     bool
+	}{
+		{
+			desc:    "add",
+			devices: []string{"router1=https://192.0.2.1", "router2=https://192.0.2.2"},
+			targets: []string{"flag", "router1", "router2"},
+			logs:    []string{`started device "router1"`, `started device "router2"`},
+		},
+		{
+			desc:    "no-op",
+			devices: []string{"router1=https://192.0.2.1", "router2=https://192.0.2.2"},
+			targets: []string{"flag", "router1", "router2"},
+		},
+		{
+			desc:    "change",
+			devices: []string{"router1=https://192.0.2.1", "router2=https://192.0.2.22"},
+			targets: []string{"flag", "router1", "router2"},
+			logs:    []string{`stopped device "router2"`, `started device "router2"`},
+		},
+		{
+			desc:    "failed add",
+			devices: []string{"router1=https://192.0.2.11", "flag=https://192.0.2.3"},
+			targets: []string{"flag", "router1", "router2"},
+			err:     true,
+		},
+		{
+			desc:    "remove",
+			devices: []string{"router1=https://192.0.2.1"},
+			targets: []string{"flag", "router1"},
+			logs:    []string{`stopped device "router2"`},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var cfg strings.Builder
+		cfg.WriteString("devices:\n")
+		for _, d := range tt.devices {
+			kv := strings.SplitN(d, "=", 2)
+			cfg.WriteString("  - name: " + kv[0] + "\n    address: " + kv[1] + "\n    username: ubnt\n    password: secret\n")
+		}
+		if err := ioutil.WriteFile(file, []byte(cfg.String()), 0600); err != nil {
+			t.Fatalf("failed to write configuration: %v", err)
+		}
+
+		buf.Reset()
+		_, err := r.reload()
+		if want, got := tt.err, err != nil; want != got {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want, got := tt.targets, p.Targets(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected targets:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var logs []string
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if l != "" {
+				// Strip the date and time prefix.
+				logs = append(logs, strings.SplitN(l, " ", 3)[2])
+			}
+		}
+		if want, got := tt.logs, logs; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected logs:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+// Package config implements the configuration file of the EdgeMAX exporter.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vaga/edgemax_exporter"
+	"github.com/vaga/edgemax_exporter/edgemax"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the exporter.
+type Config struct {
+	Web     Web      `yaml:"web"`
+	Devices []Device `yaml:"devices"`
+}
+
+// Web contains the settings of the HTTP server of the exporter. Empty
+// settings keep the defaults of the corresponding flags, and flags set on
+// the command line take precedence.
+type Web struct {
+	ListenAddress string `yaml:"listen_address"`
+	TelemetryPath string `yaml:"telemetry_path"`
+	ProbePath     string `yaml:"probe_path"`
+	// ConfigFile enables TLS or basic authentication, using the format
+	// of the Prometheus exporter-toolkit.
+	ConfigFile string `yaml:"config_file"`
+}
+
+// A Device is an EdgeMAX device, scraped using the probe endpoint with its
+// name as target.
+type Device struct {
+	Name    string        `yaml:"name"`
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Username and either Password or PasswordFile are the credentials
+	// used to log in. PasswordFile is read each time the device logs in,
+	// so that the password can be rotated without reloading.
+	Username     string `yaml:"username"`
+	Password     Secret `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+
+	TLS TLS `yaml:"tls"`
+
+	// ProxyURL is the HTTP CONNECT or SOCKS5 proxy used to reach the
+	// device. The proxy from the environment is used if it is empty. It may
+	// contain credentials, so it is redacted as well.
+	ProxyURL Secret `yaml:"proxy_url"`
+
+	// Collectors contains the names of the enabled collectors. All are
+	// enabled if it is empty.
+	Collectors []string `yaml:"collectors"`
+	// Labels are added to all metrics of the device.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// A Secret is a setting which is redacted when printed or marshaled, so
+// that it does not leak to logs or configuration dumps.
+type Secret string
+
+// redacted replaces the value of non-empty secrets.
+const redacted = "<secret>"
+
+// String implements fmt.Stringer.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString implements fmt.GoStringer, used by the %#v verb.
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// TLS contains the TLS settings used to connect to a device. Fingerprint
+// is the SHA-256 fingerprint of the device certificate, which is trusted
+// instead of a certificate authority.
+type TLS struct {
+	CAFile             string `yaml:"ca_file"`
+	Fingerprint        string `yaml:"fingerprint"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Config builds the TLS configuration of a device.
+func (t TLS) Config() (*tls.Config, error) {
+	fp, err := t.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if fp != nil {
+		edgemax.PinCertificate(c, fp)
+	}
+
+	if t.CAFile != "" {
+		b, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+		}
+	}
+
+	return c, nil
+}
+
+// validate checks the TLS settings, and returns the parsed fingerprint, if
+// any.
+func (t TLS) validate() ([]byte, error) {
+	if t.Fingerprint == "" {
+		return nil, nil
+	}
+	if t.CAFile != "" {
+		return nil, fmt.Errorf("ca_file and fingerprint are mutually exclusive")
+	}
+	return edgemax.ParseFingerprint(t.Fingerprint)
+}
+
+// An Error is an invalid setting of a configuration file.
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Line == 0 && e.File == "":
+		return e.Err.Error()
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	case e.File == "":
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Load reads and validates a configuration file. Relative paths in the
+// file are resolved from its directory.
+func Load(file string) (*Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Parse(b)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			e.File = file
+		}
+		return nil, err
+	}
+
+	dir := filepath.Dir(file)
+	c.Web.ConfigFile = resolve(dir, c.Web.ConfigFile)
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		d.PasswordFile = resolve(dir, d.PasswordFile)
+		d.TLS.CAFile = resolve(dir, d.TLS.CAFile)
+
+		// Fail early if the password file cannot be read, although it is
+		// read again when logging in.
+		if d.PasswordFile == "" {
+			continue
+		}
+		if _, err := ioutil.ReadFile(d.PasswordFile); err != nil {
+			return nil, fmt.Errorf("device %q: %v", d.Name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Parse decodes and validates a configuration. Errors are reported as
+// *Error, with an empty File.
+func Parse(b []byte) (*Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+
+	var c Config
+	if err := dec.Decode(&c); err != nil {
+		return nil, &Error{Line: errorLine(err), Err: err}
+	}
+
+	// Decode the document again to locate invalid settings.
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, &Error{Line: errorLine(err), Err: err}
+	}
+	devices := lookup(&root, "devices")
+
+	names := make(map[string]bool, len(c.Devices))
+	for i, d := range c.Devices {
+		var n *yaml.Node
+		if devices != nil && i < len(devices.Content) {
+			n = devices.Content[i]
+		}
+
+		if key, err := d.validate(); err != nil {
+			return nil, &Error{Line: line(n, key), Err: fmt.Errorf("device %d: %v", i+1, err)}
+		}
+		if names[d.Name] {
+			return nil, &Error{Line: line(n, "name"), Err: fmt.Errorf("duplicate device %q", d.Name)}
+		}
+		names[d.Name] = true
+	}
+
+	return &c, nil
+}
+
+// labelName matches valid Prometheus label names.
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validate checks the settings of a device. It returns the key of the
+// invalid setting along with the error.
+func (d *Device) validate() (string, error) {
+	switch {
+	case d.Name == "":
+		return "name", fmt.Errorf("name is required")
+	case d.Address == "":
+		return "address", fmt.Errorf("address is required")
+	case d.Username == "":
+		return "username", fmt.Errorf("username is required")
+	case d.Password == "" && d.PasswordFile == "":
+		return "password", fmt.Errorf("password or password_file is required")
+	case d.Password != "" && d.PasswordFile != "":
+		return "password_file", fmt.Errorf("password and password_file are mutually exclusive")
+	case d.Timeout < 0:
+		return "timeout", fmt.Errorf("timeout must not be negative")
+	}
+
+	if _, err := d.TLS.validate(); err != nil {
+		return "tls", fmt.Errorf("tls: %v", err)
+	}
+
+	if u, err := url.Parse(d.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "address", fmt.Errorf("address %q must be an http or https URL", d.Address)
+	}
+
+	if _, err := ParseProxyURL(string(d.ProxyURL)); err != nil {
+		return "proxy_url", err
+	}
+
+	for _, name := range d.Collectors {
+		switch name {
+		case edgemax_exporter.CollectorSystem, edgemax_exporter.CollectorInterfaces, edgemax_exporter.CollectorDPI:
+		default:
+			return "collectors", fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	for name := range d.Labels {
+		if !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return "labels", fmt.Errorf("invalid label name %q", name)
+		}
+	}
+
+	return "", nil
+}
+
+// errorLine extracts the line number of a YAML error, or returns 0.
+func errorLine(err error) int {
+	var l int
+	if e, ok := err.(*yaml.TypeError); ok && len(e.Errors) > 0 {
+		fmt.Sscanf(e.Errors[0], "line %d:", &l)
+		return l
+	}
+	fmt.Sscanf(err.Error(), "yaml: line %d:", &l)
+	return l
+}
+
+// lookup returns the value of a key of the mapping at the root of a
+// document, or nil.
+func lookup(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// line returns the line of a key of a mapping, or of the mapping itself if
+// the key is missing.
+func line(n *yaml.Node, key string) int {
+	if n == nil {
+		return 0
+	}
+	if v := lookup(n, key); v != nil {
+		return v.Line
+	}
+	return n.Line
+}
+
+// ParseProxyURL parses the URL of a proxy used to reach devices, which
+// must be an HTTP CONNECT or SOCKS5 proxy, as these are the ones supported
+// by both HTTP requests and the stats websocket. An empty URL returns nil.
+func ParseProxyURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	// The URL may contain credentials, so it is not part of the error.
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, fmt.Errorf("proxy URL must be an http or socks5 URL")
+	}
+	return u, nil
+}
+
+// resolve makes a relative path relative to dir.
+func resolve(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -1,8 +1,8 @@
 package edgemax_exporter
 
 import (
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vaga/edgemax_exporter/edgemax"
@@ -11,85 +11,111 @@ import (
 // dpiCollector is a Prometheus collector for metrics regarding EdgeMAX
 // deep packet inspection statistics.
 type dpiCollector struct {
-	receivedBytes    *prometheus.GaugeVec
-	transmittedBytes *prometheus.GaugeVec
+	receiveBytes  *prometheus.Desc
+	transmitBytes *prometheus.Desc
+
+	// Gauges exported before byte counts were exposed as counters, kept
+	// during migration if legacy is set.
+	legacy           bool
+	receivedBytes    *prometheus.Desc
+	transmittedBytes *prometheus.Desc
+
+	series *seriesCache
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
 var _ prometheus.Collector = &dpiCollector{}
 
-// newDPICollector creates a new dpiCollector.
-func newDPICollector(ch <-chan edgemax.DPIStat) *dpiCollector {
+// newDPICollector creates a new dpiCollector. Series which are no longer
+// reported are removed after ttl, and counted using expired. If legacy is
+// set, byte counts are also exported using the former gauges.
+func newDPICollector(ttl time.Duration, expired prometheus.Counter, legacy bool) *dpiCollector {
 	const subsystem = "dpi"
 	labels := []string{"client_ip", "category", "type"}
 
-	c := &dpiCollector{
-		receivedBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Subsystem: subsystem,
-				Name:      "received_bytes",
-				Help:      "Number of bytes received by devices (client download)",
-			},
-			labels,
+	return &dpiCollector{
+		receiveBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "receive_bytes_total"),
+			"Number of bytes received by devices (client download)",
+			labels, nil,
 		),
-		transmittedBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Subsystem: subsystem,
-				Name:      "transmitted_bytes",
-				Help:      "Number of bytes transmitted by devices (client upload)",
-			},
-			labels,
+		transmitBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "transmit_bytes_total"),
+			"Number of bytes transmitted by devices (client upload)",
+			labels, nil,
 		),
-	}
 
-	go c.collect(ch)
+		legacy: legacy,
+		receivedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "received_bytes"),
+			"Deprecated: use edgemax_dpi_receive_bytes_total",
+			labels, nil,
+		),
+		transmittedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "transmitted_bytes"),
+			"Deprecated: use edgemax_dpi_transmit_bytes_total",
+			labels, nil,
+		),
 
-	return c
+		series: newSeriesCache(ttl, expired),
+	}
 }
 
-// collect begins a metrics collection task for all metrics related to UniFi
-// devices.
-func (c *dpiCollector) collect(ch <-chan edgemax.DPIStat) {
-	for s := range ch {
-		for ip, a := range s {
-			for tc, stat := range a {
-				t := strings.Split(tc, "|")
-				labels := []string{ip, t[1], t[0]}
+// update stores the latest deep packet inspection stats, which are exported
+// on the next scrape.
+func (c *dpiCollector) update(s edgemax.DPIStat) {
+	u := make(seriesUpdate)
+	for ip, a := range s {
+		for tc, stat := range a {
+			counters := []edgemax.Uint64{stat.RXBytes, stat.TXBytes}
 
-				rxBytes, _ := strconv.Atoi(stat.RXBytes)
-				c.receivedBytes.WithLabelValues(labels...).Set(float64(rxBytes))
-
-				txBytes, _ := strconv.Atoi(stat.TXBytes)
-				c.transmittedBytes.WithLabelValues(labels...).Set(float64(txBytes))
+			if t := strings.SplitN(tc, "|", 2); len(t) == 2 {
+				u.set(stat, counters, ip, t[1], t[0])
+			} else {
+				u.set(stat, counters, ip, "", tc)
 			}
 		}
 	}
-}
-
-// collectors contains a list of collectors which are collected each time
-// the exporter is scraped. This list must be kept in sync with the collectors
-// in dpiCollector.
-func (c *dpiCollector) collectors() []prometheus.Collector {
-	return []prometheus.Collector{
-		c.receivedBytes,
-		c.transmittedBytes,
-	}
+	c.series.update(u)
 }
 
 // Describe sends the descriptors of each metric over to the provided channel.
 // The corresponding metric values are sent separately.
 func (c *dpiCollector) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range c.collectors() {
-		m.Describe(ch)
+	ds := []*prometheus.Desc{
+		c.receiveBytes,
+		c.transmitBytes,
+	}
+	if c.legacy {
+		ds = append(ds, c.receivedBytes, c.transmittedBytes)
+	}
+
+	for _, d := range ds {
+		ch <- d
 	}
 }
 
 // Collect sends the metric values for each metric pertaining to deep packet
 // inspection to the provided prometheus Metric channel.
 func (c *dpiCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, m := range c.collectors() {
-		m.Collect(ch)
-	}
+	c.series.each(func(v interface{}, counters []deviceCounter, labels []string) {
+		if counters[0].valid {
+			ch <- prometheus.MustNewConstMetric(c.receiveBytes, prometheus.CounterValue, counters[0].total(), labels...)
+		}
+		if counters[1].valid {
+			ch <- prometheus.MustNewConstMetric(c.transmitBytes, prometheus.CounterValue, counters[1].total(), labels...)
+		}
+
+		if !c.legacy {
+			return
+		}
+
+		stat := v.(edgemax.DPITraffic)
+		if stat.RXBytes.Valid {
+			ch <- prometheus.MustNewConstMetric(c.receivedBytes, prometheus.GaugeValue, float64(stat.RXBytes.Value), labels...)
+		}
+		if stat.TXBytes.Valid {
+			ch <- prometheus.MustNewConstMetric(c.transmittedBytes, prometheus.GaugeValue, float64(stat.TXBytes.Value), labels...)
+		}
+	})
 }
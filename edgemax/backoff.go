@@ -0,0 +1,50 @@
+package edgemax
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// minBackoff and maxBackoff bound the delay between two attempts to
+	// reconnect the stats websocket.
+	minBackoff = 1 * time.Second
+	maxBackoff = 2 * time.Minute
+	// minLoginBackoff is the initial delay before logging in again after
+	// a failed login, which must not lock out the account.
+	minLoginBackoff = 10 * time.Second
+)
+
+// A backoff computes jittered exponential delays between reconnection
+// attempts.
+type backoff struct {
+	min, max time.Duration
+	attempt  uint
+}
+
+// newBackoff creates a backoff using the specified minimum delay, and the
+// default maximum delay.
+func newBackoff(min time.Duration) *backoff {
+	return &backoff{min: min, max: maxBackoff}
+}
+
+// next returns the delay to wait before the next attempt. The delay doubles
+// on each call until it reaches the maximum, and is randomized between half
+// and the full value so that several exporters do not retry in lockstep.
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 32 {
+		if e := b.min << b.attempt; e > 0 && e < b.max {
+			d = e
+		}
+	}
+	b.attempt++
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// reset restores the initial delay after a successful attempt.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,35 @@
+package edgemax
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: time.Second, max: 10 * time.Second}
+
+	var tests = []struct {
+		desc     string
+		min, max time.Duration
+	}{
+		{desc: "first attempt", min: 500 * time.Millisecond, max: time.Second},
+		{desc: "second attempt", min: time.Second, max: 2 * time.Second},
+		{desc: "third attempt", min: 2 * time.Second, max: 4 * time.Second},
+		{desc: "fourth attempt", min: 4 * time.Second, max: 8 * time.Second},
+		{desc: "capped attempt", min: 5 * time.Second, max: 10 * time.Second},
+		{desc: "still capped", min: 5 * time.Second, max: 10 * time.Second},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		if d := b.next(); d < tt.min || d > tt.max {
+			t.Fatalf("unexpected delay: %s not in [%s, %s]", d, tt.min, tt.max)
+		}
+	}
+
+	b.reset()
+	if d := b.next(); d > time.Second {
+		t.Fatalf("unexpected delay after reset: %s", d)
+	}
+}
@@ -0,0 +1,115 @@
+package edgemax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// A DeliveryPolicy determines what happens to the messages of a stream when
+// a Subscription does not handle them as fast as they are received.
+type DeliveryPolicy int
+
+// Possible DeliveryPolicy values.
+const (
+	// KeepLatest keeps only the latest message which was not handled yet.
+	// It is the default, and suits streams which carry full snapshots.
+	KeepLatest DeliveryPolicy = iota
+	// DropOldest buffers messages, and drops the oldest one when the buffer
+	// is full.
+	DropOldest
+	// Block buffers messages, and stops reading from the websocket when the
+	// buffer is full, which delays every other Subscription of the Session.
+	Block
+)
+
+// defaultBufferSize is the number of buffered messages for the DropOldest
+// and Block policies when none is specified.
+const defaultBufferSize = 16
+
+// WithPolicy returns a copy of the Subscription which delivers messages
+// according to p, buffering up to size messages. size is ignored by
+// KeepLatest, and a default is used if it is not positive.
+func (s Subscription) WithPolicy(p DeliveryPolicy, size int) Subscription {
+	s.policy = p
+	s.size = size
+	return s
+}
+
+// A subscriber delivers messages to a Subscription from its own goroutine,
+// so that a slow handler does not stall the websocket reader.
+type subscriber struct {
+	Subscription
+	queue chan json.RawMessage
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newSubscriber creates a subscriber for sub, which stops when ctx is
+// canceled or its cancel function is called.
+func newSubscriber(ctx context.Context, sub Subscription) *subscriber {
+	size := sub.size
+	switch {
+	case sub.policy == KeepLatest:
+		size = 1
+	case size <= 0:
+		size = defaultBufferSize
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &subscriber{
+		Subscription: sub,
+		queue:        make(chan json.RawMessage, size),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// run delivers queued messages to the handler until the subscriber stops.
+// Messages the handler rejects are reported to o.
+func (s *subscriber) run(o Observer) {
+	for {
+		select {
+		case m := <-s.queue:
+			if err := s.handle(s.ctx, m); err != nil {
+				log.Println(err)
+
+				var de *DecodeError
+				if errors.As(err, &de) {
+					o.Rejected(de)
+				}
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// push queues a message according to the delivery policy. It reports
+// whether an older message was dropped to make room for it.
+func (s *subscriber) push(m json.RawMessage) (dropped bool) {
+	if s.policy == Block {
+		select {
+		case s.queue <- m:
+		case <-s.ctx.Done():
+		}
+		return false
+	}
+
+	for {
+		select {
+		case s.queue <- m:
+			return dropped
+		default:
+		}
+
+		// The queue is full: make room, unless the handler just did.
+		select {
+		case <-s.queue:
+			dropped = true
+		default:
+		}
+	}
+}
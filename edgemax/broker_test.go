@@ -0,0 +1,76 @@
+package edgemax
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSubscriberPush(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		policy  DeliveryPolicy
+		size    int
+		in      []string
+		out     []string
+		dropped int
+	}{
+		{
+			desc:   "keep latest with a single message",
+			policy: KeepLatest,
+			in:     []string{"1"},
+			out:    []string{"1"},
+		},
+		{
+			desc:    "keep latest ignores size",
+			policy:  KeepLatest,
+			size:    4,
+			in:      []string{"1", "2", "3"},
+			out:     []string{"3"},
+			dropped: 2,
+		},
+		{
+			desc:    "drop oldest",
+			policy:  DropOldest,
+			size:    2,
+			in:      []string{"1", "2", "3", "4"},
+			out:     []string{"3", "4"},
+			dropped: 2,
+		},
+		{
+			desc:   "block within buffer",
+			policy: Block,
+			size:   3,
+			in:     []string{"1", "2", "3"},
+			out:    []string{"1", "2", "3"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		sub := RawStats("foo", func(json.RawMessage) {}).WithPolicy(tt.policy, tt.size)
+		s := newSubscriber(context.Background(), sub)
+
+		var dropped int
+		for _, m := range tt.in {
+			if s.push(json.RawMessage(m)) {
+				dropped++
+			}
+		}
+		s.cancel()
+
+		if want, got := tt.dropped, dropped; want != got {
+			t.Fatalf("unexpected number of dropped messages:\n- want: %d\n-  got: %d", want, got)
+		}
+
+		var out []string
+		for len(s.queue) > 0 {
+			out = append(out, string(<-s.queue))
+		}
+		if want, got := tt.out, out; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected queued messages:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
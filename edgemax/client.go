@@ -1,8 +1,11 @@
 package edgemax
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
@@ -19,8 +22,83 @@ import (
 // Client.Login must be called and return a nil error before any additional
 // actions can be performed with a Client.
 type Client struct {
-	client *http.Client
-	url    *url.URL
+	client      *http.Client
+	url         *url.URL
+	wsURL       *url.URL
+	origin      string
+	observer    Observer
+	credentials CredentialsProvider
+
+	// mu protects the credentials remembered by Login, and serializes
+	// logins performed when the session expires.
+	mu                 sync.Mutex
+	username, password string
+
+	// csrfMu protects the CSRF token used by EdgeOS 2.x devices.
+	csrfMu    sync.Mutex
+	csrfToken string
+
+	// heartbeatInterval, minBackoff and minLoginBackoff pace the Sessions
+	// of the Client, and are only shortened by tests.
+	heartbeatInterval time.Duration
+	minBackoff        time.Duration
+	minLoginBackoff   time.Duration
+}
+
+// A CredentialsProvider returns the username and password used to log in
+// to an EdgeMAX device.
+type CredentialsProvider func() (username, password string, err error)
+
+// PasswordFile returns a CredentialsProvider which reads the password from
+// a file each time it is called, so that rotated credentials are used when
+// logging in again. A trailing newline is ignored.
+func PasswordFile(username, file string) CredentialsProvider {
+	return func() (string, string, error) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		return username, strings.TrimRight(string(b), "\r\n"), nil
+	}
+}
+
+// An Option configures optional behavior of a Client.
+type Option func(c *Client)
+
+// WithCredentialsProvider registers a CredentialsProvider which is queried
+// each time the Client must log in again after its session expired. Without
+// one, the Client reuses the credentials given to Client.Login.
+func WithCredentialsProvider(p CredentialsProvider) Option {
+	return func(c *Client) {
+		c.credentials = p
+	}
+}
+
+// WithWebsocketURL overrides the URL of the stats websocket, for devices
+// behind a reverse proxy which serves it elsewhere. By default, it is
+// derived from the device address, using the ws scheme for http and wss
+// for https.
+func WithWebsocketURL(u *url.URL) Option {
+	return func(c *Client) {
+		wsURL := *u
+		c.wsURL = &wsURL
+	}
+}
+
+// WithOrigin overrides the Origin header sent when dialing the stats
+// websocket, which defaults to the scheme and host of the device address.
+func WithOrigin(origin string) Option {
+	return func(c *Client) {
+		c.origin = origin
+	}
+}
+
+// WithObserver registers an Observer which is notified of events such as
+// websocket reconnections.
+func WithObserver(o Observer) Option {
+	return func(c *Client) {
+		c.observer = o
+	}
 }
 
 const (
@@ -30,15 +108,29 @@ const (
 	// sessionCookie is the name of the session cookie used to authenticate
 	// against EdgeMAX devices.
 	sessionCookie = "PHPSESSID"
+	// dataPath is the API endpoint used to retrieve device data.
+	dataPath = "/api/edge/data.json"
+	// wsPath is the endpoint of the stats websocket.
+	wsPath = "/ws/stats"
+	// heartbeatPath is the API endpoint used to keep a session active.
+	heartbeatPath = "/api/edge/heartbeat.json"
+	// logoutPath is the endpoint used to end a session.
+	logoutPath = "/logout"
+	// heartbeatInterval is the interval between two heartbeat requests.
+	heartbeatInterval = 10 * time.Second
+	// defaultTimeout is the timeout used for HTTP requests and websocket
+	// handshakes when no other timeout is specified.
+	defaultTimeout = 10 * time.Second
 )
 
 // NewClient creates a new Client, using the input EdgeMAX device address
 // and an optional HTTP client. If no HTTP client is specified, a default
-// one will be used.
+// one will be used. Options may be specified to further configure the
+// Client.
 //
 // Client.Login must be called and return a nil error before any additional
 // actions can be performed with a Client.
-func NewClient(addr string, client *http.Client) (*Client, error) {
+func NewClient(addr string, client *http.Client, options ...Option) (*Client, error) {
 	u, err := url.Parse(strings.TrimRight(addr, "/"))
 	if err != nil {
 		return nil, err
@@ -46,7 +138,7 @@ func NewClient(addr string, client *http.Client) (*Client, error) {
 
 	if client == nil {
 		client = &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: defaultTimeout,
 		}
 	}
 
@@ -58,161 +150,419 @@ func NewClient(addr string, client *http.Client) (*Client, error) {
 		client.Jar = jar
 	}
 
-	return &Client{
-		client: client,
-		url:    u,
-	}, nil
+	c := &Client{
+		client:   client,
+		url:      u,
+		observer: nopObserver{},
+
+		heartbeatInterval: heartbeatInterval,
+		minBackoff:        minBackoff,
+		minLoginBackoff:   minLoginBackoff,
+	}
+	for _, o := range options {
+		o(c)
+	}
+
+	return c, nil
 }
 
 // Login authenticates against the EdgeMAX device using the specified username
 // and password. Login must be called and return a nil error before any
 // additional actions can be performed.
+//
+// The credentials are remembered so that the Client can log in again
+// transparently when its session expires, unless a CredentialsProvider was
+// registered using WithCredentialsProvider.
 func (c *Client) Login(username, password string) error {
+	return c.LoginContext(context.Background(), username, password)
+}
+
+// LoginContext is like Login, but the authentication request is bound to
+// ctx.
+func (c *Client) LoginContext(ctx context.Context, username, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.username, c.password = username, password
+	return c.login(ctx, username, password)
+}
+
+// relogin authenticates again against the EdgeMAX device after its session
+// expired.
+func (c *Client) relogin(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	username, password := c.username, c.password
+	if c.credentials != nil {
+		var err error
+		username, password, err = c.credentials()
+		if err != nil {
+			return fmt.Errorf("could not retrieve credentials: %v", err)
+		}
+	}
+
+	log.Println("session expired, logging in again")
+	return c.login(ctx, username, password)
+}
+
+// login performs the authentication request, and checks that the EdgeMAX
+// device accepted it.
+func (c *Client) login(ctx context.Context, username, password string) (err error) {
+	defer func() { c.observer.Login(err) }()
+
 	v := make(url.Values, 2)
 	v.Set("username", username)
 	v.Set("password", password)
 
-	_, err := c.client.PostForm(c.url.String(), v)
-	return err
+	req, err := c.newRequest(ctx, http.MethodPost, "/", strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// EdgeOS answers a successful login with a redirect to the dashboard,
+	// and a failed one with the login page, so redirects must not be
+	// followed to tell them apart. When logging in again, the CSRF token
+	// of the previous session is sent.
+	res, err := c.doWith(c.noRedirect(), req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// A new session comes with a new CSRF token, if any, so the token of
+	// the previous session is not kept.
+	if csrfFrom(res) == "" {
+		c.setCSRF("")
+	}
+
+	if err := checkLogin(c.url, res); err != nil {
+		return err
+	}
+
+	for _, ck := range c.client.Jar.Cookies(c.url) {
+		if ck.Name == sessionCookie && ck.Value != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no %s cookie in login response", ErrNotEdgeOS, sessionCookie)
 }
 
-// Stats opens a websocket connection to an EdgeMAX device to retrieve
-// statistics which are sent using the socket.
-func (c *Client) Stats(
-	systemCh chan<- SystemStat,
-	dpiCh chan<- DPIStat,
-	ifacesCh chan<- InterfacesStat,
-) (func(), error) {
-	conn, err := c.dial()
+// Logout ends the session of the Client on the EdgeMAX device, rather than
+// leaving it until it expires. Sessions using the Client should be closed
+// first.
+func (c *Client) Logout() error {
+	return c.LogoutContext(context.Background())
+}
+
+// LogoutContext is like Logout, but the request is bound to ctx.
+func (c *Client) LogoutContext(ctx context.Context) error {
+	req, err := c.newRequest(ctx, http.MethodGet, logoutPath, nil)
+	if err != nil {
+		return err
+	}
+
+	// EdgeOS redirects to the login page, which does not need to be
+	// retrieved.
+	res, err := c.doWith(c.noRedirect(), req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected HTTP status in logout response: %s", res.Status)
+	}
+
+	c.setCSRF("")
+	return nil
+}
+
+// noRedirect returns a copy of the HTTP client of the Client, which does
+// not follow redirects.
+func (c *Client) noRedirect() *http.Client {
+	hc := *c.client
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &hc
+}
+
+// checkLogin interprets the response of an EdgeMAX device to a login
+// request.
+func checkLogin(u *url.URL, res *http.Response) error {
+	// Only the beginning of the page is needed to identify it.
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	page := strings.ToLower(string(body))
+
+	// A lockout is only told apart by its message on pages rejecting the
+	// login, since any other page may contain the same words.
+	tooMany := strings.Contains(page, "too many")
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		return ErrTooManyAttempts
+	case res.StatusCode >= 300 && res.StatusCode < 400:
+		loc, err := res.Location()
+		if err != nil {
+			return fmt.Errorf("%w: invalid redirect in login response: %v", ErrNotEdgeOS, err)
+		}
+		if loc.Host != u.Host {
+			return fmt.Errorf("%w: login redirected to %q", ErrNotEdgeOS, loc.Host)
+		}
+		return nil
+	case res.StatusCode == http.StatusUnauthorized,
+		res.StatusCode == http.StatusForbidden:
+		if tooMany {
+			return ErrTooManyAttempts
+		}
+		return ErrInvalidCredentials
+	case res.StatusCode == http.StatusOK && strings.Contains(page, `name="password"`):
+		// The login page is served again when authentication failed,
+		// along with a message when the account is locked out.
+		if tooMany {
+			return ErrTooManyAttempts
+		}
+		return ErrInvalidCredentials
+	case res.StatusCode == http.StatusOK:
+		return fmt.Errorf("%w: unexpected page in login response", ErrNotEdgeOS)
+	case res.StatusCode >= 500:
+		return fmt.Errorf("unexpected HTTP status in login response: %s", res.Status)
+	default:
+		return fmt.Errorf("%w: unexpected HTTP status in login response: %s", ErrNotEdgeOS, res.Status)
+	}
+}
+
+// newRequest creates an HTTP request bound to ctx for the specified path on
+// the EdgeMAX device.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url.String()+path, body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("User-Agent", userAgent)
 
-	var sessionID string
-	for _, c := range c.client.Jar.Cookies(c.url) {
-		if c.Name == sessionCookie {
-			sessionID = c.Value
-			break
+	return req, nil
+}
+
+// do sends an HTTP request using the Client's session. The CSRF token is
+// attached to state-changing requests, and refreshed from the response if
+// the device rotated it.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	return c.doWith(c.client, req)
+}
+
+// doWith is like do, but sends the request using hc, a copy of the HTTP
+// client of the Client.
+func (c *Client) doWith(hc *http.Client, req *http.Request) (*http.Response, error) {
+	if isStateChanging(req.Method) {
+		if token := c.csrf(); token != "" {
+			req.Header.Set(csrfToken, token)
 		}
 	}
 
+	res, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	c.updateCSRF(res)
+
+	return res, nil
+}
+
+// connect dials the stats websocket and subscribes to the specified
+// statistics streams.
+func (c *Client) connect(ctx context.Context, streams []string) (*websocket.Conn, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID := c.sessionID()
+
 	if err := conn.WriteMessage(websocket.TextMessage, marshalWS(
 		connectRequest{
-			Subscribe: []stat{
-				stat{Name: "system-stats"},
-				stat{Name: "export"},
-				stat{Name: "interfaces"},
-			},
+			Subscribe: stats(streams),
 			SessionID: sessionID,
 		},
 	)); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	doneCh := make(chan struct{})
-	wg := new(sync.WaitGroup)
+	return conn, nil
+}
+
+// sessionID returns the value of the session cookie.
+func (c *Client) sessionID() string {
+	for _, c := range c.client.Jar.Cookies(c.url) {
+		if c.Name == sessionCookie {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// websocketURL returns the URL of the stats websocket, adapted from the
+// device address unless it was overridden with WithWebsocketURL.
+func (c *Client) websocketURL() *url.URL {
+	if c.wsURL != nil {
+		return c.wsURL
+	}
 
-	go c.keepAlive(wg, doneCh)
-	go c.read(conn, wg, doneCh, systemCh, dpiCh, ifacesCh)
+	u := *c.url
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	// Keep the path prefix of the address, as for HTTP requests.
+	u.Path += wsPath
+	u.RawPath = ""
+	u.RawQuery, u.Fragment = "", ""
+	return &u
+}
 
-	return func() { close(doneCh); wg.Wait() }, nil
+// websocketOrigin returns the Origin header of the stats websocket.
+func (c *Client) websocketOrigin() string {
+	if c.origin != "" {
+		return c.origin
+	}
+	return c.url.Scheme + "://" + c.url.Host
 }
 
 // dial initializes the websocket used for Client.Stats
-func (c *Client) dial() (*websocket.Conn, error) {
-	// Websocket URL is adapted from HTTP URL
-	wsURL := *c.url
-	wsURL.Scheme = "wss"
-	wsURL.Path = "/ws/stats"
-
-	d := &websocket.Dialer{EnableCompression: true}
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	d := &websocket.Dialer{
+		EnableCompression: true,
+		HandshakeTimeout:  c.client.Timeout,
+	}
+	if d.HandshakeTimeout == 0 {
+		d.HandshakeTimeout = defaultTimeout
+	}
 
-	// Copy TLS config from client if using standard *http.Transport, so that
-	// using InsecureHTTPClient can also apply to websocket connections
-	if tr, ok := c.client.Transport.(*http.Transport); ok {
+	// Copy TLS config and proxy from client if using standard
+	// *http.Transport, so that a CA bundle, a pinned certificate or
+	// InsecureSkipVerify also apply to websocket connections, which take
+	// the same path through HTTP CONNECT or SOCKS5 proxies
+	rt := c.client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if tr, ok := rt.(*http.Transport); ok {
 		d.TLSClientConfig = tr.TLSClientConfig
+		d.Proxy = tr.Proxy
 	}
 
-	conn, _, err := d.Dial(wsURL.String(), http.Header{
-		"Origin": []string{c.url.Scheme + "://" + c.url.Host},
+	conn, res, err := d.DialContext(ctx, c.websocketURL().String(), http.Header{
+		"Origin": []string{c.websocketOrigin()},
 	})
 	if err != nil {
+		if res != nil && (res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden) {
+			return nil, errSessionExpired
+		}
 		return nil, err
 	}
 
 	return conn, nil
 }
 
-// read receives raw stats from a websocket and decodes them into
-// Stat structs of various types.
-func (c *Client) read(
-	conn *websocket.Conn,
-	wg *sync.WaitGroup,
-	doneCh chan struct{},
-	systemCh chan<- SystemStat, dpiCh chan<- DPIStat, ifacesCh chan<- InterfacesStat,
-) {
-	defer conn.Close()
-	wg.Add(1)
-	defer wg.Done()
-
-	for {
-		select {
-		case <-doneCh:
-			return
-		default:
-		}
+// SystemInfo retrieves information about the EdgeMAX device, such as its
+// model and firmware version.
+func (c *Client) SystemInfo() (*SystemInfo, error) {
+	return c.SystemInfoContext(context.Background())
+}
 
-		_, m, err := conn.ReadMessage()
-		if err != nil {
-			log.Println("read:", err)
-			close(doneCh)
-			return
-		}
-		rm := make(map[string]json.RawMessage)
-		if err := unmarshalWS(m, &rm); err != nil {
-			log.Println("unmarshal:", err)
+// SystemInfoContext is like SystemInfo, but the requests are bound to ctx.
+func (c *Client) SystemInfoContext(ctx context.Context) (*SystemInfo, error) {
+	var si SystemInfo
+	err := c.data(ctx, "sys_info", &si)
+	if err == errSessionExpired {
+		if err = c.relogin(ctx); err == nil {
+			err = c.data(ctx, "sys_info", &si)
 		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		for sn, sk := range rm {
-			switch sn {
-			case "system-stats":
-				var s SystemStat
-				if err := json.Unmarshal(sk, &s); err != nil {
-					log.Println("unmarshal system-stats:", err)
-				}
-				systemCh <- s
-			case "export":
-				var s DPIStat
-				if err := json.Unmarshal(sk, &s); err != nil {
-					log.Println("unmarshal export:", err)
-				}
-				dpiCh <- s
-			case "interfaces":
-				var s InterfacesStat
-				if err := json.Unmarshal(sk, &s); err != nil {
-					log.Println("unmarshal interfaces:", err)
-				}
-				ifacesCh <- s
-			}
-		}
+	return &si, nil
+}
+
+// data retrieves a kind of device data, and decodes it into v.
+func (c *Client) data(ctx context.Context, kind string, v interface{}) error {
+	req, err := c.newRequest(ctx, http.MethodGet, dataPath+"?data="+url.QueryEscape(kind), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return errSessionExpired
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected HTTP status: %s", res.Status)
 	}
+
+	var d struct {
+		Success string          `json:"success"`
+		Error   string          `json:"error"`
+		Output  json.RawMessage `json:"output"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&d); err != nil {
+		return fmt.Errorf("could not decode %s data: %v", kind, err)
+	}
+	if d.Success != "1" {
+		return fmt.Errorf("could not retrieve %s data: %q", kind, d.Error)
+	}
+
+	return json.Unmarshal(d.Output, v)
 }
 
-// keepalive sends heartbeat requests at regular intervals to the EdgeMAX
-// device to keep a session active while Client.Stats is running.
-func (c *Client) keepAlive(wg *sync.WaitGroup, doneCh chan struct{}) {
-	wg.Add(1)
-	defer wg.Done()
+// heartbeat sends a single heartbeat request to the EdgeMAX device. It
+// returns errSessionExpired if the device rejected the session.
+func (c *Client) heartbeat(ctx context.Context) error {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s?_=%d", heartbeatPath, time.Now().UnixNano()), nil)
+	if err != nil {
+		return err
+	}
 
-	for {
-		_, err := c.client.Get(fmt.Sprintf("%s/api/edge/heartbeat.json?_=%d", c.url.String(), time.Now().UnixNano()))
-		if err != nil {
-			log.Printf("could not request edgemax API: %v", err)
-		}
-		select {
-		case <-time.After(10 * time.Second):
-		case <-doneCh:
-			return
-		}
+	res, err := c.do(req)
+	if err != nil {
+		return err
 	}
+	defer res.Body.Close()
+
+	// An expired session is either rejected, or redirected to the login
+	// page. The device address may have a path prefix.
+	switch {
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return errSessionExpired
+	case res.Request.URL.Path != c.url.Path+heartbeatPath:
+		return errSessionExpired
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected HTTP status: %s", res.Status)
+	}
+
+	var h struct {
+		Session *bool `json:"SESSION"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		return fmt.Errorf("could not decode heartbeat: %v", err)
+	}
+	if h.Session != nil && !*h.Session {
+		return errSessionExpired
+	}
+
+	return nil
 }
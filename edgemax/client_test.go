@@ -0,0 +1,312 @@
+package edgemax
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestClientRelogin(t *testing.T) {
+	var logins int
+	valid := "1"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			return
+		}
+		if r.FormValue("username") != "ubnt" || r.FormValue("password") != "secret" {
+			t.Errorf("unexpected credentials: %q/%q", r.FormValue("username"), r.FormValue("password"))
+			return
+		}
+		logins++
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: valid})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc(heartbeatPath, func(w http.ResponseWriter, r *http.Request) {
+		if ck, err := r.Cookie(sessionCookie); err != nil || ck.Value != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"SESSION":true,"PING":true}`))
+	})
+
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := NewClient(s.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if err := c.heartbeat(context.Background()); err != nil {
+		t.Fatalf("unexpected heartbeat error with valid session: %v", err)
+	}
+
+	// Expire the session on the device side.
+	valid = "2"
+	if err := c.heartbeat(context.Background()); err != errSessionExpired {
+		t.Fatalf("unexpected heartbeat error:\n- want: %v\n-  got: %v", errSessionExpired, err)
+	}
+	if err := c.relogin(context.Background()); err != nil {
+		t.Fatalf("unexpected error while renewing session: %v", err)
+	}
+	if err := c.heartbeat(context.Background()); err != nil {
+		t.Fatalf("unexpected heartbeat error after renewal: %v", err)
+	}
+
+	if want, got := 2, logins; want != got {
+		t.Fatalf("unexpected number of logins:\n- want: %d\n-  got: %d", want, got)
+	}
+}
+
+func TestClientLogin(t *testing.T) {
+	var tests = []struct {
+		desc string
+		h    http.HandlerFunc
+		err  error
+	}{
+		{
+			desc: "OK",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "foo"})
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+			},
+		},
+		{
+			desc: "invalid credentials",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`<form method="post"><input type="password" name="password"></form>`))
+			},
+			err: ErrInvalidCredentials,
+		},
+		{
+			desc: "too many attempts",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`<p>Too many failed login attempts.</p><form method="post"><input type="password" name="password"></form>`))
+			},
+			err: ErrTooManyAttempts,
+		},
+		{
+			desc: "too many attempts rejected",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Too many failed login attempts.", http.StatusForbidden)
+			},
+			err: ErrTooManyAttempts,
+		},
+		{
+			desc: "redirect mentioning too many",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "foo"})
+				w.Header().Set("Location", "/")
+				w.WriteHeader(http.StatusSeeOther)
+				w.Write([]byte(`<p>Too many widgets on the dashboard.</p>`))
+			},
+		},
+		{
+			desc: "unexpected page mentioning too many",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`<p>Too many widgets on the dashboard.</p>`))
+			},
+			err: ErrNotEdgeOS,
+		},
+		{
+			desc: "not found",
+			h:    http.NotFound,
+			err:  ErrNotEdgeOS,
+		},
+		{
+			desc: "redirect to another host",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "https://example.com/", http.StatusFound)
+			},
+			err: ErrNotEdgeOS,
+		},
+		{
+			desc: "no session cookie",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+			},
+			err: ErrNotEdgeOS,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		s := httptest.NewServer(tt.h)
+
+		c, err := NewClient(s.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		err = c.Login("ubnt", "secret")
+		s.Close()
+
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", tt.err, err)
+		}
+	}
+}
+
+func TestClientSystemInfo(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "session"})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc(dataPath, func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "sys_info", r.URL.Query().Get("data"); want != got {
+			t.Errorf("unexpected data kind:\n- want: %q\n-  got: %q", want, got)
+		}
+		w.Write([]byte(`{"success":"1","output":{"host_name":"ubnt","model":"ER-X","sw_ver":"v2.0.9"}}`))
+	})
+
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := NewClient(s.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	si, err := c.SystemInfo()
+	if err != nil {
+		t.Fatalf("failed to retrieve system information: %v", err)
+	}
+
+	if want, got := (SystemInfo{Hostname: "ubnt", Model: "ER-X", Firmware: "v2.0.9"}), *si; want != got {
+		t.Fatalf("unexpected system information:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientWebsocketURL(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		addr    string
+		options []Option
+		url     string
+		origin  string
+	}{
+		{
+			desc:   "HTTPS",
+			addr:   "https://192.0.2.1",
+			url:    "wss://192.0.2.1/ws/stats",
+			origin: "https://192.0.2.1",
+		},
+		{
+			desc:   "plain HTTP with port",
+			addr:   "http://192.0.2.1:8080",
+			url:    "ws://192.0.2.1:8080/ws/stats",
+			origin: "http://192.0.2.1:8080",
+		},
+		{
+			desc:   "path prefix",
+			addr:   "https://proxy.example.com/router1/",
+			url:    "wss://proxy.example.com/router1/ws/stats",
+			origin: "https://proxy.example.com",
+		},
+		{
+			desc: "overridden endpoint and origin",
+			addr: "https://192.0.2.1",
+			options: []Option{
+				WithWebsocketURL(&url.URL{Scheme: "wss", Host: "proxy.example.com", Path: "/stats/router1"}),
+				WithOrigin("https://proxy.example.com"),
+			},
+			url:    "wss://proxy.example.com/stats/router1",
+			origin: "https://proxy.example.com",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		c, err := NewClient(tt.addr, nil, tt.options...)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		if want, got := tt.url, c.websocketURL().String(); want != got {
+			t.Fatalf("unexpected websocket URL:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := tt.origin, c.websocketOrigin(); want != got {
+			t.Fatalf("unexpected websocket origin:\n- want: %q\n-  got: %q", want, got)
+		}
+	}
+}
+
+func TestClientLogout(t *testing.T) {
+	var logouts int
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "session"})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc(logoutPath, func(w http.ResponseWriter, r *http.Request) {
+		ck, err := r.Cookie(sessionCookie)
+		if err != nil || ck.Value != "session" {
+			t.Errorf("logout without session cookie: %v", err)
+		}
+		logouts++
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := NewClient(s.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Fatalf("failed to log out: %v", err)
+	}
+
+	if want, got := 1, logouts; want != got {
+		t.Fatalf("unexpected number of logouts:\n- want: %d\n-  got: %d", want, got)
+	}
+}
+
+func TestPasswordFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "edgemax_password")
+	if err != nil {
+		t.Fatalf("failed to create password file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	p := PasswordFile("ubnt", f.Name())
+	for _, password := range []string{"secret", "rotated"} {
+		if err := ioutil.WriteFile(f.Name(), []byte(password+"\n"), 0600); err != nil {
+			t.Fatalf("failed to write password file: %v", err)
+		}
+
+		username, got, err := p()
+		if err != nil {
+			t.Fatalf("failed to read credentials: %v", err)
+		}
+		if username != "ubnt" || got != password {
+			t.Fatalf("unexpected credentials:\n- want: ubnt %s\n-  got: %s %s", password, username, got)
+		}
+	}
+
+	os.Remove(f.Name())
+	if _, _, err := p(); err == nil {
+		t.Fatal("expected an error for missing password file")
+	}
+}
@@ -0,0 +1,55 @@
+package edgemax
+
+import "net/http"
+
+// csrfToken is the name of both the cookie in which EdgeOS 2.x devices hand
+// out their CSRF token, and the header in which the token must be sent back
+// with each state-changing request. EdgeOS 1.x devices do not use it.
+const csrfToken = "X-CSRF-TOKEN"
+
+// csrf returns the current CSRF token, if any.
+func (c *Client) csrf() string {
+	c.csrfMu.Lock()
+	defer c.csrfMu.Unlock()
+
+	return c.csrfToken
+}
+
+// setCSRF replaces the current CSRF token.
+func (c *Client) setCSRF(token string) {
+	c.csrfMu.Lock()
+	defer c.csrfMu.Unlock()
+
+	c.csrfToken = token
+}
+
+// updateCSRF captures the CSRF token set by the device in a response, if
+// any.
+func (c *Client) updateCSRF(res *http.Response) {
+	if token := csrfFrom(res); token != "" {
+		c.setCSRF(token)
+	}
+}
+
+// csrfFrom returns the CSRF token set by the device in a response, either
+// as a cookie or as a header.
+func csrfFrom(res *http.Response) string {
+	token := res.Header.Get(csrfToken)
+	for _, ck := range res.Cookies() {
+		if ck.Name == csrfToken {
+			token = ck.Value
+		}
+	}
+	return token
+}
+
+// isStateChanging reports whether an HTTP method may modify state on the
+// device, and thus requires a CSRF token.
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
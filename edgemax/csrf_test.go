@@ -0,0 +1,110 @@
+package edgemax
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testOperationPath = "/api/edge/operation/test.json"
+
+func TestClientCSRF(t *testing.T) {
+	var tests = []struct {
+		desc   string
+		tokens []string
+	}{
+		{
+			desc: "EdgeOS 1.x without CSRF token",
+		},
+		{
+			desc:   "EdgeOS 2.x with CSRF token",
+			tokens: []string{"foo"},
+		},
+		{
+			desc:   "EdgeOS 2.x with rotated CSRF token",
+			tokens: []string{"foo", "bar", "baz"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		// The fake device hands out the next token with each response, and
+		// expects the previous one on state-changing requests, including
+		// logging in again.
+		var (
+			n        int
+			loggedIn bool
+		)
+		token := func() string {
+			if len(tt.tokens) == 0 {
+				return ""
+			}
+			return tt.tokens[n%len(tt.tokens)]
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			var want string
+			if loggedIn {
+				want = token()
+			}
+			if got := r.Header.Get(csrfToken); want != got {
+				t.Errorf("unexpected CSRF token on login:\n- want: %q\n-  got: %q", want, got)
+			}
+			loggedIn = true
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "session"})
+			if tok := token(); tok != "" {
+				http.SetCookie(w, &http.Cookie{Name: csrfToken, Value: tok})
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		})
+		mux.HandleFunc(testOperationPath, func(w http.ResponseWriter, r *http.Request) {
+			if want, got := token(), r.Header.Get(csrfToken); want != got {
+				t.Errorf("unexpected CSRF token:\n- want: %q\n-  got: %q", want, got)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			n++
+			if tok := token(); tok != "" {
+				http.SetCookie(w, &http.Cookie{Name: csrfToken, Value: tok})
+			}
+		})
+
+		s := httptest.NewServer(mux)
+
+		c, err := NewClient(s.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		if err := c.Login("ubnt", "secret"); err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+
+		for j := 0; j < 3; j++ {
+			req, err := c.newRequest(context.Background(), http.MethodPost, testOperationPath, nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			res, err := c.do(req)
+			if err != nil {
+				t.Fatalf("failed to perform request: %v", err)
+			}
+			res.Body.Close()
+
+			if want, got := http.StatusOK, res.StatusCode; want != got {
+				t.Fatalf("unexpected HTTP status for request %d:\n- want: %d\n-  got: %d", j, want, got)
+			}
+		}
+
+		if err := c.relogin(context.Background()); err != nil {
+			t.Fatalf("failed to log in again: %v", err)
+		}
+		if want, got := token(), c.csrf(); want != got {
+			t.Fatalf("unexpected CSRF token after logging in again:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		s.Close()
+	}
+}
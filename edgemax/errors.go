@@ -0,0 +1,70 @@
+package edgemax
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidCredentials is returned by Client.Login when the EdgeMAX
+	// device rejected the username or password.
+	ErrInvalidCredentials = errors.New("edgemax: invalid username or password")
+	// ErrTooManyAttempts is returned by Client.Login when the EdgeMAX device
+	// refuses to authenticate after too many failed attempts.
+	ErrTooManyAttempts = errors.New("edgemax: too many failed login attempts")
+	// ErrNotEdgeOS is returned by Client.Login when the remote host does not
+	// behave like an EdgeMAX device.
+	ErrNotEdgeOS = errors.New("edgemax: host is not an EdgeOS device")
+	// ErrSessionClosed is returned by Session.Err after Session.Close was
+	// called.
+	ErrSessionClosed = errors.New("edgemax: session closed")
+	// ErrInvalidSubscription is returned for a Subscription without a
+	// stream name, or with a nil handler or channel.
+	ErrInvalidSubscription = errors.New("edgemax: subscription requires a stream name and a non-nil handler or channel")
+)
+
+var (
+	// errSessionExpired is returned when the EdgeMAX device no longer
+	// accepts the session cookie of a Client.
+	errSessionExpired = errors.New("edgemax: session expired")
+	// errSessionRenewed is returned by Client.read when the websocket was
+	// closed because the Client logged in again.
+	errSessionRenewed = errors.New("edgemax: session renewed")
+)
+
+// A DecodeError reports a stats message which was rejected because it could
+// not be decoded. Field is the path of the malformed field, made of JSON
+// field names, and is empty if the message is not valid JSON.
+type DecodeError struct {
+	Stream string
+	Field  string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	switch {
+	case e.Stream == "":
+		return fmt.Sprintf("edgemax: malformed stats message: %v", e.Err)
+	case e.Field == "":
+		return fmt.Sprintf("edgemax: malformed %s message: %v", e.Stream, e.Err)
+	}
+	return fmt.Sprintf("edgemax: malformed %s field %s: %v", e.Stream, e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A FingerprintError is returned when the certificate of an EdgeMAX device
+// does not match the fingerprint pinned using PinCertificate. Got is empty
+// if the device presented no certificate.
+type FingerprintError struct {
+	Want []byte
+	Got  []byte
+}
+
+func (e *FingerprintError) Error() string {
+	return fmt.Sprintf("edgemax: certificate fingerprint mismatch: want SHA-256 %s, got %s",
+		formatFingerprint(e.Want), formatFingerprint(e.Got))
+}
@@ -0,0 +1,186 @@
+package edgemax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Uint64 is an unsigned integer stat. EdgeMAX devices encode numbers
+// either as JSON numbers or as strings, and omit stats they do not report.
+// Valid is false if the stat is missing or empty.
+type Uint64 struct {
+	Value uint64
+	Valid bool
+
+	err error
+}
+
+// UnmarshalJSON implements json.Unmarshaler. A malformed value does not
+// fail the decoding, but makes the stat containing it rejected.
+func (u *Uint64) UnmarshalJSON(b []byte) error {
+	*u = Uint64{}
+
+	s, ok := unquote(b)
+	if !ok {
+		return nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		u.err = fmt.Errorf("invalid unsigned integer %q", s)
+		return nil
+	}
+
+	*u = Uint64{Value: v, Valid: true}
+	return nil
+}
+
+// A Float64 is a floating point stat. Valid is false if the stat is missing
+// or empty.
+type Float64 struct {
+	Value float64
+	Valid bool
+
+	err error
+}
+
+// UnmarshalJSON implements json.Unmarshaler. A malformed value does not
+// fail the decoding, but makes the stat containing it rejected.
+func (f *Float64) UnmarshalJSON(b []byte) error {
+	*f = Float64{}
+
+	s, ok := unquote(b)
+	if !ok {
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		f.err = fmt.Errorf("invalid number %q", s)
+		return nil
+	}
+
+	*f = Float64{Value: v, Valid: true}
+	return nil
+}
+
+// A Bool is a boolean stat. Valid is false if the stat is missing or empty.
+type Bool struct {
+	Value bool
+	Valid bool
+
+	err error
+}
+
+// UnmarshalJSON implements json.Unmarshaler. A malformed value does not
+// fail the decoding, but makes the stat containing it rejected.
+func (bo *Bool) UnmarshalJSON(b []byte) error {
+	*bo = Bool{}
+
+	s, ok := unquote(b)
+	if !ok {
+		return nil
+	}
+
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		bo.err = fmt.Errorf("invalid boolean %q", s)
+		return nil
+	}
+
+	*bo = Bool{Value: v, Valid: true}
+	return nil
+}
+
+// unquote returns the text of a JSON scalar, which may be quoted. It reports
+// false for null and empty values.
+func unquote(b []byte) (string, bool) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		return "", false
+	}
+
+	s := string(b)
+	if b[0] == '"' {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return "", false
+		}
+	}
+
+	s = strings.TrimSpace(s)
+	return s, s != ""
+}
+
+// A checker is a stat which records whether its value was malformed.
+type checker interface {
+	check() error
+}
+
+func (u Uint64) check() error  { return u.err }
+func (f Float64) check() error { return f.err }
+func (bo Bool) check() error   { return bo.err }
+
+// malformed looks for a malformed stat in v. It returns the path of its
+// field, made of JSON field names without map keys so that it identifies
+// the field regardless of the interface or client it belongs to.
+func malformed(v interface{}) (string, error) {
+	return walk(reflect.ValueOf(v), "")
+}
+
+// walk implements malformed.
+func walk(v reflect.Value, path string) (string, error) {
+	if v.IsValid() && v.CanInterface() {
+		if c, ok := v.Interface().(checker); ok {
+			return path, c.check()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return walk(v.Elem(), path)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = f.Name
+			}
+			if p, err := walk(v.Field(i), join(path, name)); err != nil {
+				return p, err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if p, err := walk(v.MapIndex(k), path); err != nil {
+				return p, err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if p, err := walk(v.Index(i), path); err != nil {
+				return p, err
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// join appends a field name to a path.
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
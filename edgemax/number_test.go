@@ -0,0 +1,154 @@
+package edgemax
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNumberUnmarshal(t *testing.T) {
+	var tests = []struct {
+		desc  string
+		in    string
+		value uint64
+		valid bool
+		err   bool
+	}{
+		{
+			desc:  "JSON number",
+			in:    `12`,
+			value: 12,
+			valid: true,
+		},
+		{
+			desc:  "quoted number",
+			in:    `"18446744073709551615"`,
+			value: 18446744073709551615,
+			valid: true,
+		},
+		{
+			desc: "null",
+			in:   `null`,
+		},
+		{
+			desc: "empty string",
+			in:   `""`,
+		},
+		{
+			desc: "negative",
+			in:   `"-1"`,
+			err:  true,
+		},
+		{
+			desc: "not a number",
+			in:   `"foo"`,
+			err:  true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var u Uint64
+		if err := json.Unmarshal([]byte(tt.in), &u); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+
+		if want, got := tt.value, u.Value; want != got {
+			t.Fatalf("unexpected value:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.valid, u.Valid; want != got {
+			t.Fatalf("unexpected validity:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.err, u.check() != nil; want != got {
+			t.Fatalf("unexpected malformed value:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	var tests = []struct {
+		desc   string
+		stream string
+		in     string
+		v      interface{}
+		field  string
+		err    bool
+	}{
+		{
+			desc:   "valid system stats",
+			stream: StreamSystem,
+			in:     `{"cpu":"5","uptime":10,"mem":"20.5","cpu_cores":["1","2"],"temps":{"CPU":"42.5"}}`,
+			v:      &SystemStat{},
+		},
+		{
+			desc:   "malformed memory",
+			stream: StreamSystem,
+			in:     `{"cpu":"5","memory":{"total":"1024","free":"lots"}}`,
+			v:      &SystemStat{},
+			field:  "memory.free",
+			err:    true,
+		},
+		{
+			desc:   "malformed temperature",
+			stream: StreamSystem,
+			in:     `{"temps":{"CPU":"hot"}}`,
+			v:      &SystemStat{},
+			field:  "temps",
+			err:    true,
+		},
+		{
+			desc:   "malformed interface counter",
+			stream: StreamInterfaces,
+			in:     `{"eth0":{"up":"true","stats":{"rx_bytes":"1"}},"eth1":{"stats":{"tx_bytes":"x"}}}`,
+			v:      &InterfacesStat{},
+			field:  "stats.tx_bytes",
+			err:    true,
+		},
+		{
+			desc:   "malformed interface state",
+			stream: StreamInterfaces,
+			in:     `{"eth0":{"l1up":"maybe"}}`,
+			v:      &InterfacesStat{},
+			field:  "l1up",
+			err:    true,
+		},
+		{
+			desc:   "malformed DPI traffic",
+			stream: StreamDPI,
+			in:     `{"192.0.2.1":{"Web|Web":{"rx_bytes":"1.5","tx_bytes":"2"}}}`,
+			v:      &DPIStat{},
+			field:  "rx_bytes",
+			err:    true,
+		},
+		{
+			desc:   "invalid JSON",
+			stream: StreamDPI,
+			in:     `[1]`,
+			v:      &DPIStat{},
+			err:    true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		err := decode(tt.stream, json.RawMessage(tt.in), tt.v)
+		if want, got := tt.err, err != nil; want != got {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err == nil {
+			continue
+		}
+
+		de, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("unexpected error type: %T", err)
+		}
+		if want, got := tt.stream, de.Stream; want != got {
+			t.Fatalf("unexpected stream:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := tt.field, de.Field; want != got {
+			t.Fatalf("unexpected field:\n- want: %q\n-  got: %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package edgemax
+
+// An Observer is notified of events occurring in a Client, so that callers
+// can instrument it. Methods may be called concurrently.
+type Observer interface {
+	// Up is called when the stats feed of the device becomes available or
+	// unavailable, that is when the stats websocket is connected or lost.
+	// A failed heartbeat does not make the feed unavailable while frames
+	// are still received.
+	Up(up bool)
+
+	// Login is called after each attempt to log in. err is nil when the
+	// attempt succeeded.
+	Login(err error)
+
+	// Received is called for each websocket message, with its size in
+	// bytes.
+	Received(size int)
+
+	// Message is called for each message of a stream received on the
+	// websocket, whether or not a Subscription handles it.
+	Message(stream string)
+
+	// Reconnect is called after each attempt to re-establish the stats
+	// websocket. err is nil when the attempt succeeded.
+	Reconnect(err error)
+
+	// Dropped is called when a message of a stream is dropped because a
+	// Subscription did not keep up with it.
+	Dropped(stream string)
+
+	// Rejected is called when a message is rejected because it could not be
+	// decoded. Stream is empty if the message could not be split into
+	// streams.
+	Rejected(err *DecodeError)
+}
+
+// nopObserver is the Observer used when none is configured.
+type nopObserver struct{}
+
+func (nopObserver) Up(bool)               {}
+func (nopObserver) Login(error)           {}
+func (nopObserver) Received(int)          {}
+func (nopObserver) Message(string)        {}
+func (nopObserver) Reconnect(error)       {}
+func (nopObserver) Dropped(string)        {}
+func (nopObserver) Rejected(*DecodeError) {}
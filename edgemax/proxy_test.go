@@ -0,0 +1,244 @@
+package edgemax
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestClientProxy(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	var tests = []struct {
+		desc  string
+		proxy func(t *testing.T) *testProxy
+	}{
+		{
+			desc:  "HTTP CONNECT",
+			proxy: newTestHTTPProxy,
+		},
+		{
+			desc:  "SOCKS5",
+			proxy: newTestSOCKS5Proxy,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		p := tt.proxy(t)
+		defer p.Close()
+
+		tr := d.Client().Transport.(*http.Transport).Clone()
+		tr.Proxy = http.ProxyURL(p.url)
+
+		c, err := NewClient(d.URL, &http.Client{Transport: tr})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		if err := c.Login("ubnt", "secret"); err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+		if p.tunnels() == 0 {
+			t.Fatal("login did not go through proxy")
+		}
+
+		// The HTTPS connection of the login is kept alive, so a new
+		// tunnel is only opened for the websocket.
+		n := p.tunnels()
+		conn, err := c.connect(context.Background(), []string{"system-stats"})
+		if err != nil {
+			t.Fatalf("failed to connect websocket: %v", err)
+		}
+		conn.Close()
+
+		if want, got := n+1, p.tunnels(); want != got {
+			t.Fatalf("unexpected number of tunnels:\n- want: %d\n-  got: %d", want, got)
+		}
+	}
+}
+
+// A testProxy is a stand-in proxy, which counts the tunnels it opened.
+type testProxy struct {
+	url   *url.URL
+	close func()
+
+	mu sync.Mutex
+	n  int
+}
+
+// newTestHTTPProxy starts a testProxy accepting HTTP CONNECT requests.
+func newTestHTTPProxy(t *testing.T) *testProxy {
+	p := &testProxy{}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %v", err)
+			upstream.Close()
+			return
+		}
+		p.tunnel(conn, upstream)
+	}))
+
+	p.url, _ = url.Parse(s.URL)
+	p.close = s.Close
+	return p
+}
+
+// newTestSOCKS5Proxy starts a testProxy implementing the CONNECT command of
+// SOCKS5, without authentication.
+func newTestSOCKS5Proxy(t *testing.T) *testProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	p := &testProxy{
+		url:   &url.URL{Scheme: "socks5", Host: l.Addr().String()},
+		close: func() { l.Close() },
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				addr, err := socks5Handshake(conn)
+				if err != nil {
+					t.Errorf("failed SOCKS5 handshake: %v", err)
+					conn.Close()
+					return
+				}
+
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					// General SOCKS server failure.
+					conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+					conn.Close()
+					upstream.Close()
+					return
+				}
+				p.tunnel(conn, upstream)
+			}()
+		}
+	}()
+
+	return p
+}
+
+// socks5Handshake reads the greeting and CONNECT request of a SOCKS5
+// client, and returns the requested address.
+func socks5Handshake(conn net.Conn) (string, error) {
+	// Version, and number of authentication methods.
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	if b[0] != 5 {
+		return "", errors.New("unsupported SOCKS version")
+	}
+	if _, err := io.ReadFull(conn, make([]byte, b[1])); err != nil {
+		return "", err
+	}
+	// No authentication required.
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	// Version, command, reserved byte and address type.
+	b = make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	if b[1] != 1 {
+		return "", errors.New("unsupported SOCKS command")
+	}
+
+	var host string
+	switch b[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if b[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errors.New("unsupported SOCKS address type")
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// tunnel counts a tunnel, and copies data between its connections until
+// one of them is closed.
+func (p *testProxy) tunnel(a, b net.Conn) {
+	p.mu.Lock()
+	p.n++
+	p.mu.Unlock()
+
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// tunnels returns the number of tunnels opened by the testProxy.
+func (p *testProxy) tunnels() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.n
+}
+
+// Close stops the testProxy.
+func (p *testProxy) Close() {
+	p.close()
+}
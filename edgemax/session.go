@@ -0,0 +1,473 @@
+package edgemax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A Session is a running subscription to the statistics of an EdgeMAX
+// device, created by Client.Stats. It owns the goroutines which keep the
+// session active and read from the websocket.
+type Session struct {
+	c      *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	renewCh chan struct{}
+	doneCh  chan struct{}
+	wg      sync.WaitGroup
+
+	// subsMu protects the subscribers, and serializes writes to the
+	// current websocket connection. closed is set once no goroutine may
+	// be added to wg anymore.
+	subsMu sync.Mutex
+	subs   map[string][]*subscriber
+	conn   *websocket.Conn
+	closed bool
+
+	mu  sync.Mutex
+	err error
+
+	// loginMu serializes the logins performed by keepAlive and supervise
+	// when the session expires. After a failed login, no other one is
+	// attempted before loginAfter.
+	loginMu      sync.Mutex
+	loginBackoff *backoff
+	loginAfter   time.Time
+	loginErr     error
+}
+
+// Stats opens a websocket connection to an EdgeMAX device to retrieve
+// statistics from the streams selected by subs, which are sent using the
+// socket. If the connection is lost, the Session reconnects and subscribes
+// again in the background, waiting between attempts with a jittered
+// exponential backoff. If the session expires, the Client logs in again and
+// renews the subscription.
+//
+// Session.Close must be called to stop retrieving statistics.
+func (c *Client) Stats(subs ...Subscription) (*Session, error) {
+	return c.StatsContext(context.Background(), subs...)
+}
+
+// StatsContext is like Stats, but the initial connection is bound to ctx,
+// and the Session ends when ctx is canceled.
+func (c *Client) StatsContext(ctx context.Context, subs ...Subscription) (*Session, error) {
+	if err := validate(subs); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Session{
+		c:       c,
+		ctx:     ctx,
+		cancel:  cancel,
+		renewCh: make(chan struct{}, 1),
+		doneCh:  make(chan struct{}),
+		subs:    make(map[string][]*subscriber),
+
+		loginBackoff: newBackoff(c.minLoginBackoff),
+	}
+	for _, sub := range subs {
+		s.subs[sub.stream] = append(s.subs[sub.stream], newSubscriber(ctx, sub))
+	}
+
+	conn, err := s.connect()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	for _, ss := range s.subs {
+		for _, sub := range ss {
+			s.start(sub)
+		}
+	}
+	c.observer.Up(true)
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.keepAlive()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.supervise(conn)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		// The Session may also end because its parent context was canceled.
+		s.stop(ctx.Err())
+
+		s.subsMu.Lock()
+		s.closed = true
+		s.subsMu.Unlock()
+
+		s.wg.Wait()
+		c.observer.Up(false)
+		close(s.doneCh)
+	}()
+
+	return s, nil
+}
+
+// Subscribe adds subscriptions to the Session. Streams which were not
+// selected yet are subscribed to on the current websocket connection.
+func (s *Session) Subscribe(subs ...Subscription) error {
+	if err := validate(subs); err != nil {
+		return err
+	}
+
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	var streams []string
+	for _, sub := range subs {
+		if len(s.subs[sub.stream]) == 0 {
+			streams = append(streams, sub.stream)
+		}
+		s.add(sub)
+	}
+
+	s.write(connectRequest{Subscribe: stats(streams)})
+	return nil
+}
+
+// Unsubscribe removes all subscriptions to the specified streams from the
+// Session, and unsubscribes from them on the current websocket connection.
+func (s *Session) Unsubscribe(streams ...string) error {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	var removed []string
+	for _, st := range streams {
+		if len(s.subs[st]) == 0 {
+			continue
+		}
+		for _, sub := range s.subs[st] {
+			sub.cancel()
+		}
+		delete(s.subs, st)
+		removed = append(removed, st)
+	}
+
+	s.write(connectRequest{Unsubscribe: stats(removed)})
+	return nil
+}
+
+// add starts delivering messages to a Subscription. The caller must hold
+// subsMu, and check that the Session is not closed.
+func (s *Session) add(sub Subscription) {
+	ss := newSubscriber(s.ctx, sub)
+	s.start(ss)
+	s.subs[sub.stream] = append(s.subs[sub.stream], ss)
+}
+
+// start runs a subscriber in a goroutine owned by the Session.
+func (s *Session) start(ss *subscriber) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ss.run(s.c.observer)
+	}()
+}
+
+// write sends a subscription change on the current websocket connection.
+// The caller must hold subsMu. A failed write is not fatal, since the
+// current subscriptions are sent again when reconnecting.
+func (s *Session) write(cr connectRequest) {
+	if s.conn == nil || (len(cr.Subscribe) == 0 && len(cr.Unsubscribe) == 0) {
+		return
+	}
+
+	cr.SessionID = s.c.sessionID()
+	if err := s.conn.WriteMessage(websocket.TextMessage, marshalWS(cr)); err != nil {
+		log.Printf("could not update stats subscription: %v", err)
+	}
+}
+
+// connect dials the stats websocket and subscribes to the streams of the
+// Session.
+func (s *Session) connect() (*websocket.Conn, error) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	streams := make([]string, 0, len(s.subs))
+	for st := range s.subs {
+		streams = append(streams, st)
+	}
+	sort.Strings(streams)
+
+	conn, err := s.c.connect(s.ctx, streams)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+
+	return conn, nil
+}
+
+// subscribers returns the subscribers to a stream.
+func (s *Session) subscribers(stream string) []*subscriber {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+
+	return s.subs[stream]
+}
+
+// Close stops the Session and waits for its goroutines to exit. It returns
+// the error which made the Session fail, if any. Close may be called
+// several times and concurrently.
+func (s *Session) Close() error {
+	s.stop(ErrSessionClosed)
+	<-s.doneCh
+
+	if err := s.Err(); err != ErrSessionClosed &&
+		err != context.Canceled && err != context.DeadlineExceeded {
+		return err
+	}
+	return nil
+}
+
+// Done returns a channel which is closed once the Session has ended and all
+// of its goroutines have exited.
+func (s *Session) Done() <-chan struct{} {
+	return s.doneCh
+}
+
+// Err returns nil while the Session is running. After Done is closed, it
+// returns ErrSessionClosed if Close was called, the error of the context
+// passed to Client.StatsContext if it was canceled, or the error which made
+// the Session fail.
+func (s *Session) Err() error {
+	select {
+	case <-s.doneCh:
+	default:
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// stop records the reason why the Session ends, unless one was already
+// recorded, and cancels its goroutines.
+func (s *Session) stop(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+}
+
+// relogin logs in again after the session expired. Invalid credentials
+// cannot become valid unless a CredentialsProvider supplies new ones, and
+// a locked out account stays locked while logins are attempted, so in
+// these cases the Session fails. Otherwise, failed logins are retried with
+// an exponential backoff shared by all callers, so that the account is not
+// locked out.
+func (s *Session) relogin() error {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+
+	if d := time.Until(s.loginAfter); d > 0 {
+		return fmt.Errorf("not logging in again for %s after failure: %v", d.Round(time.Millisecond), s.loginErr)
+	}
+
+	err := s.c.relogin(s.ctx)
+	switch {
+	case err == nil:
+		s.loginBackoff.reset()
+		s.loginAfter = time.Time{}
+	case s.c.credentials == nil && (errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrTooManyAttempts)):
+		s.stop(err)
+	default:
+		s.loginErr = err
+		s.loginAfter = time.Now().Add(s.loginBackoff.next())
+	}
+	return err
+}
+
+// supervise reads stats from conn, and re-establishes the websocket
+// whenever reading fails or the session is renewed, until the Session ends.
+func (s *Session) supervise(conn *websocket.Conn) {
+	b := newBackoff(s.c.minBackoff)
+	for {
+		err := s.read(conn)
+		if err == nil {
+			return
+		}
+
+		// A renewed session only needs a new subscription, which can be
+		// sent right away.
+		immediate := err == errSessionRenewed
+		if immediate {
+			log.Println("resubscribing to stats with renewed session")
+		} else {
+			log.Println("read:", err)
+			s.c.observer.Up(false)
+		}
+
+		for {
+			if !immediate {
+				d := b.next()
+				log.Printf("reconnecting stats websocket in %s", d)
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-s.ctx.Done():
+					t.Stop()
+					return
+				}
+			}
+			immediate = false
+
+			// The websocket may have been closed because the session
+			// expired, in which case subscribing again would fail.
+			switch err = s.c.heartbeat(s.ctx); err {
+			case nil:
+				conn, err = s.connect()
+			case errSessionExpired:
+				if err = s.relogin(); err == nil {
+					conn, err = s.connect()
+				}
+			}
+			if s.ctx.Err() != nil {
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			s.c.observer.Reconnect(err)
+			s.c.observer.Up(err == nil)
+			if err == nil {
+				log.Println("reconnected stats websocket")
+				b.reset()
+				break
+			}
+			log.Printf("could not reconnect stats websocket: %v", err)
+		}
+	}
+}
+
+// read receives raw stats from a websocket and queues them for the
+// subscribers to their stream. It returns a nil error once the Session
+// ends, errSessionRenewed once the session is renewed, or the error which
+// caused the websocket to fail.
+func (s *Session) read(conn *websocket.Conn) error {
+	defer conn.Close()
+
+	// Unblock ReadMessage as soon as the Session ends or the session is
+	// renewed.
+	var renewed int32
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+		case <-s.renewCh:
+			atomic.StoreInt32(&renewed, 1)
+		case <-stopCh:
+			return
+		}
+		conn.Close()
+	}()
+
+	// Frames may span several messages.
+	var dec frameDecoder
+	for {
+		_, m, err := conn.ReadMessage()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			if atomic.LoadInt32(&renewed) == 1 {
+				return errSessionRenewed
+			}
+			return err
+		}
+
+		s.c.observer.Received(len(m))
+
+		docs, err := dec.decode(m)
+		if err != nil {
+			s.reject(&DecodeError{Err: err})
+		}
+
+		for _, doc := range docs {
+			rm := make(map[string]json.RawMessage)
+			if err := json.Unmarshal(doc, &rm); err != nil {
+				s.reject(&DecodeError{Err: err})
+				continue
+			}
+
+			for sn, sk := range rm {
+				s.c.observer.Message(sn)
+				for _, sub := range s.subscribers(sn) {
+					if sub.push(sk) {
+						s.c.observer.Dropped(sn)
+					}
+				}
+				if s.ctx.Err() != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// reject logs and reports a message which could not be decoded.
+func (s *Session) reject(err *DecodeError) {
+	log.Println(err)
+	s.c.observer.Rejected(err)
+}
+
+// keepalive sends heartbeat requests at regular intervals to the EdgeMAX
+// device to keep a session active while the Session is running. When the
+// session has expired, it logs in again and notifies the reader. Whether
+// the stats feed is up is only reported by supervise, from the state of
+// the websocket.
+func (s *Session) keepAlive() {
+	t := time.NewTicker(s.c.heartbeatInterval)
+	defer t.Stop()
+
+	for {
+		switch err := s.c.heartbeat(s.ctx); {
+		case s.ctx.Err() != nil, err == nil:
+		case err == errSessionExpired:
+			if err := s.relogin(); err != nil {
+				log.Printf("could not log in to edgemax API: %v", err)
+				break
+			}
+			select {
+			case s.renewCh <- struct{}{}:
+			default:
+			}
+		default:
+			log.Printf("could not request edgemax API: %v", err)
+		}
+
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
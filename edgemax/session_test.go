@@ -0,0 +1,794 @@
+package edgemax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionClose(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	systemCh := make(chan SystemStat)
+	s, err := newTestClient(t, d).Stats(SystemStatsChan(systemCh))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+
+	d.send(`{"system-stats":{"cpu":"5","uptime":"10","mem":"20"}}`)
+
+	select {
+	case st := <-systemCh:
+		if want, got := (SystemStat{
+			CPU:    Float64{Value: 5, Valid: true},
+			Uptime: Uint64{Value: 10, Valid: true},
+			Mem:    Float64{Value: 20, Valid: true},
+		}), st; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected system stat:\n- want: %v\n-  got: %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for system stat")
+	}
+
+	if err := s.Err(); err != nil {
+		t.Fatalf("unexpected error for running session: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close session twice: %v", err)
+	}
+
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("session not done after close")
+	}
+	if want, got := ErrSessionClosed, s.Err(); want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestSessionConcurrentClose(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	// Nothing reads from the channels, so the reader is blocked sending a
+	// stat while the session is closed.
+	s, err := newTestClient(t, d).Stats(InterfacesStatsChan(make(chan InterfacesStat)))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	d.send(`{"interfaces":{"eth0":{"mac":"00:00:00:00:00:00"}}}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Close(); err != nil {
+				t.Errorf("failed to close session: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSessionContextCanceled(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := newTestClient(t, d).StatsContext(ctx, SystemStatsChan(make(chan SystemStat)))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for session to end")
+	}
+	if want, got := context.Canceled, s.Err(); want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+}
+
+func TestSessionSubscribe(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	if _, err := newTestClient(t, d).Stats(SystemStatsChan(nil)); err != ErrInvalidSubscription {
+		t.Fatalf("unexpected error for nil channel:\n- want: %v\n-  got: %v", ErrInvalidSubscription, err)
+	}
+
+	rawCh := make(chan json.RawMessage)
+	s, err := newTestClient(t, d).Stats(RawStats("foo", func(raw json.RawMessage) {
+		rawCh <- raw
+	}))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer s.Close()
+
+	d.send(`{"foo":{"bar":1}}`)
+	select {
+	case raw := <-rawCh:
+		if want, got := `{"bar":1}`, string(raw); want != got {
+			t.Fatalf("unexpected raw stat:\n- want: %s\n-  got: %s", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for raw stat")
+	}
+
+	if err := s.Subscribe(SystemStats(func(SystemStat) {})); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	if err := s.Unsubscribe("foo", "bar"); err != nil {
+		t.Fatalf("failed to unsubscribe: %v", err)
+	}
+
+	want := []connectRequest{
+		{Subscribe: []stat{{Name: "foo"}}, SessionID: "session"},
+		{Subscribe: []stat{{Name: StreamSystem}}, SessionID: "session"},
+		{Unsubscribe: []stat{{Name: "foo"}}, SessionID: "session"},
+	}
+	for i, w := range want {
+		select {
+		case cr := <-d.requests:
+			if !reflect.DeepEqual(w, cr) {
+				t.Fatalf("unexpected request %d:\n- want: %v\n-  got: %v", i, w, cr)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for request %d", i)
+		}
+	}
+}
+
+func TestSessionObserver(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	o := &testObserver{messages: make(chan string, 1)}
+	c, err := NewClient(d.URL, d.Client(), WithObserver(o))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	s, err := c.Stats(RawStats("foo", func(json.RawMessage) {}))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+
+	const doc = `{"foo":{"bar":1}}`
+	d.send(doc)
+	select {
+	case st := <-o.messages:
+		if want, got := "foo", st; want != got {
+			t.Fatalf("unexpected stream:\n- want: %s\n-  got: %s", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	o.mu.Lock()
+	if want, got := 1, o.logins; want != got {
+		t.Fatalf("unexpected number of logins:\n- want: %d\n-  got: %d", want, got)
+	}
+	if want, got := len(strconv.Itoa(len(doc))+"\n"+doc), o.received; want != got {
+		t.Fatalf("unexpected number of bytes received:\n- want: %d\n-  got: %d", want, got)
+	}
+	if !o.up {
+		t.Fatal("session not reported up")
+	}
+	o.mu.Unlock()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.up {
+		t.Fatal("closed session reported up")
+	}
+}
+
+func TestSessionReconnect(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	o := &testObserver{}
+	c, err := NewClient(d.URL, d.Client(), WithObserver(o))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	c.minBackoff = 10 * time.Millisecond
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	systemCh := make(chan SystemStat)
+	s, err := c.Stats(SystemStatsChan(systemCh))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer s.Close()
+	<-d.handshakes
+	<-d.requests
+
+	// The first attempt fails, and the next one succeeds.
+	d.drop(true)
+	<-d.handshakes
+	d.accept()
+
+	select {
+	case <-d.handshakes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for websocket to be dialed again")
+	}
+	select {
+	case cr := <-d.requests:
+		if want, got := (connectRequest{Subscribe: stats([]string{"system-stats"}), SessionID: "session"}), cr; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected subscription:\n- want: %v\n-  got: %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for subscription")
+	}
+
+	d.send(`{"system-stats":{"cpu":"5"}}`)
+	select {
+	case st := <-systemCh:
+		if want, got := (Float64{Value: 5, Valid: true}), st.CPU; want != got {
+			t.Fatalf("unexpected CPU usage:\n- want: %v\n-  got: %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for system stat after reconnecting")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if want, got := 2, len(o.reconnects); want != got {
+		t.Fatalf("unexpected number of reconnections:\n- want: %d\n-  got: %d", want, got)
+	}
+	if o.reconnects[0] == nil || o.reconnects[1] != nil {
+		t.Fatalf("unexpected reconnection results: %v", o.reconnects)
+	}
+	if !o.up {
+		t.Fatal("session not reported up after reconnecting")
+	}
+}
+
+func TestSessionUpWhileReconnecting(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	o := &testObserver{ups: make(chan bool, 64)}
+	c, err := NewClient(d.URL, d.Client(), WithObserver(o))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	c.heartbeatInterval = 20 * time.Millisecond
+	c.minBackoff = 100 * time.Millisecond
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	s, err := c.Stats(RawStats("foo", func(json.RawMessage) {}))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer s.Close()
+	<-d.requests
+	waitUp(t, o, true)
+
+	// Heartbeats keep succeeding while the websocket cannot be
+	// re-established.
+	d.drop(true)
+	waitUp(t, o, false)
+	for i := 0; i < 5; i++ {
+		select {
+		case <-d.heartbeats:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for heartbeat")
+		}
+	}
+	for len(o.ups) > 0 {
+		if <-o.ups {
+			t.Fatal("session reported up while reconnecting")
+		}
+	}
+
+	d.accept()
+	waitUp(t, o, true)
+}
+
+// waitUp waits until a testObserver is notified that the stats feed is up
+// or down.
+func waitUp(t *testing.T, o *testObserver, up bool) {
+	for {
+		select {
+		case got := <-o.ups:
+			if got == up {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for up to be %v", up)
+		}
+	}
+}
+
+func TestSessionWebsocketURL(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		tls     bool
+		options func(d *testDevice) []Option
+		path    string
+		origin  func(d *testDevice) string
+	}{
+		{
+			desc:   "HTTPS",
+			tls:    true,
+			path:   "/ws/stats",
+			origin: func(d *testDevice) string { return d.URL },
+		},
+		{
+			desc:   "plain HTTP",
+			path:   "/ws/stats",
+			origin: func(d *testDevice) string { return d.URL },
+		},
+		{
+			desc: "overridden endpoint and origin",
+			options: func(d *testDevice) []Option {
+				return []Option{
+					WithWebsocketURL(&url.URL{Scheme: "ws", Host: d.Listener.Addr().String(), Path: "/ws/router1"}),
+					WithOrigin("http://lab.example.com"),
+				}
+			},
+			path:   "/ws/router1",
+			origin: func(*testDevice) string { return "http://lab.example.com" },
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		d := newUnstartedTestDevice(t)
+		if tt.tls {
+			d.StartTLS()
+		} else {
+			d.Start()
+		}
+
+		var options []Option
+		if tt.options != nil {
+			options = tt.options(d)
+		}
+
+		systemCh := make(chan SystemStat)
+		s, err := newTestClient(t, d, options...).Stats(SystemStatsChan(systemCh))
+		if err != nil {
+			t.Fatalf("failed to open session: %v", err)
+		}
+
+		r := <-d.handshakes
+		if want, got := tt.path, r.URL.Path; want != got {
+			t.Fatalf("unexpected websocket path:\n- want: %q\n-  got: %q", want, got)
+		}
+		if want, got := tt.origin(d), r.Header.Get("Origin"); want != got {
+			t.Fatalf("unexpected websocket origin:\n- want: %q\n-  got: %q", want, got)
+		}
+
+		d.send(`{"system-stats":{"cpu":"5"}}`)
+		select {
+		case st := <-systemCh:
+			if want, got := (Float64{Value: 5, Valid: true}), st.CPU; want != got {
+				t.Fatalf("unexpected CPU usage:\n- want: %v\n-  got: %v", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for system stat")
+		}
+
+		s.Close()
+		d.Close()
+	}
+}
+
+func TestSessionPathPrefix(t *testing.T) {
+	d := newUnstartedTestDevice(t)
+	d.Config.Handler = http.StripPrefix("/router1", d.Config.Handler)
+	d.StartTLS()
+	defer d.Close()
+
+	c, err := NewClient(d.URL+"/router1/", d.Client())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	systemCh := make(chan SystemStat)
+	s, err := c.Stats(SystemStatsChan(systemCh))
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer s.Close()
+	<-d.requests
+
+	// The first heartbeat is sent right away. A heartbeat mistaken for
+	// an expired session would renew the subscription.
+	select {
+	case <-d.heartbeats:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for heartbeat")
+	}
+	select {
+	case cr := <-d.requests:
+		t.Fatalf("unexpected subscription after heartbeat: %v", cr)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	d.send(`{"system-stats":{"cpu":"5"}}`)
+	select {
+	case st := <-systemCh:
+		if want, got := (Float64{Value: 5, Valid: true}), st.CPU; want != got {
+			t.Fatalf("unexpected CPU usage:\n- want: %v\n-  got: %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for system stat")
+	}
+}
+
+func TestSessionLoginBackoff(t *testing.T) {
+	var tests = []struct {
+		desc        string
+		credentials CredentialsProvider
+		reject      func(w http.ResponseWriter)
+		err         error
+	}{
+		{
+			desc: "invalid credentials from provider",
+			credentials: func() (string, string, error) {
+				return "ubnt", "wrong", nil
+			},
+			reject: func(w http.ResponseWriter) {
+				w.Write([]byte(`<form><input name="password" type="password"></form>`))
+			},
+		},
+		{
+			desc: "too many attempts",
+			reject: func(w http.ResponseWriter) {
+				http.Error(w, "too many failed login attempts", http.StatusTooManyRequests)
+			},
+			err: ErrTooManyAttempts,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		// Once the session has expired, the device rejects all logins.
+		var (
+			mu      sync.Mutex
+			expired bool
+			logins  int
+		)
+		d := newUnstartedTestDevice(t)
+		h := d.Config.Handler
+		d.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			exp := expired
+			login := r.Method == http.MethodPost && r.URL.Path == "/"
+			if exp && login {
+				logins++
+			}
+			mu.Unlock()
+
+			switch {
+			case exp && login:
+				tt.reject(w)
+			case exp && r.URL.Path == heartbeatPath:
+				w.Write([]byte(`{"SESSION":false}`))
+			default:
+				h.ServeHTTP(w, r)
+			}
+		})
+		d.StartTLS()
+
+		var options []Option
+		if tt.credentials != nil {
+			options = append(options, WithCredentialsProvider(tt.credentials))
+		}
+		c := newTestClient(t, d, options...)
+		c.heartbeatInterval = 10 * time.Millisecond
+		c.minBackoff = 10 * time.Millisecond
+		c.minLoginBackoff = 100 * time.Millisecond
+
+		s, err := c.Stats(RawStats("foo", func(json.RawMessage) {}))
+		if err != nil {
+			t.Fatalf("failed to open session: %v", err)
+		}
+		<-d.requests
+
+		// Both the heartbeats and the websocket reconnections find the
+		// session expired, and share the login backoff.
+		mu.Lock()
+		expired = true
+		mu.Unlock()
+		d.drop(false)
+
+		select {
+		case <-s.Done():
+		case <-time.After(time.Second):
+		}
+
+		mu.Lock()
+		n := logins
+		mu.Unlock()
+
+		if tt.err != nil {
+			if want, got := tt.err, s.Err(); !errors.Is(got, want) {
+				t.Fatalf("unexpected session error:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := 1, n; want != got {
+				t.Fatalf("unexpected number of logins:\n- want: %d\n-  got: %d", want, got)
+			}
+		} else {
+			if err := s.Err(); err != nil {
+				t.Fatalf("unexpected session error: %v", err)
+			}
+			// Logins are attempted after 0, 50-100, 150-300, 350-700 and
+			// 750-1500ms.
+			if n < 2 || n > 5 {
+				t.Fatalf("unexpected number of logins in 1s: %d", n)
+			}
+		}
+
+		s.Close()
+		d.Close()
+	}
+}
+
+// A testObserver records the events of a Client, and sends the streams of
+// received messages on messages if it is set.
+type testObserver struct {
+	nopObserver
+	messages chan string
+	ups      chan bool
+
+	mu         sync.Mutex
+	up         bool
+	logins     int
+	received   int
+	reconnects []error
+}
+
+func (o *testObserver) Up(up bool) {
+	o.mu.Lock()
+	o.up = up
+	o.mu.Unlock()
+
+	if o.ups != nil {
+		select {
+		case o.ups <- up:
+		default:
+		}
+	}
+}
+
+func (o *testObserver) Login(error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.logins++
+}
+
+func (o *testObserver) Reconnect(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.reconnects = append(o.reconnects, err)
+}
+
+func (o *testObserver) Received(size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.received += size
+}
+
+func (o *testObserver) Message(stream string) {
+	if o.messages != nil {
+		o.messages <- stream
+	}
+}
+
+// A testDevice is a fake EdgeMAX device, which accepts any credentials and
+// forwards frames to its stats websockets. Subscription requests received
+// on the websockets are sent on requests, their handshakes on handshakes,
+// and heartbeat requests are signaled on heartbeats.
+type testDevice struct {
+	*httptest.Server
+	frames     chan string
+	requests   chan connectRequest
+	handshakes chan *http.Request
+	heartbeats chan struct{}
+
+	// mu protects the open websockets, which are closed by drop, and
+	// refuse, which makes new websocket handshakes fail.
+	mu     sync.Mutex
+	conns  map[*websocket.Conn]chan struct{}
+	refuse bool
+}
+
+// newTestDevice starts a testDevice serving HTTPS.
+func newTestDevice(t *testing.T) *testDevice {
+	d := newUnstartedTestDevice(t)
+	d.StartTLS()
+	return d
+}
+
+// newUnstartedTestDevice creates a testDevice, which must be started with
+// Start or StartTLS. Stats websockets are served under /ws/.
+func newUnstartedTestDevice(t *testing.T) *testDevice {
+	d := &testDevice{
+		frames:     make(chan string),
+		requests:   make(chan connectRequest, 16),
+		handshakes: make(chan *http.Request, 16),
+		heartbeats: make(chan struct{}, 16),
+		conns:      make(map[*websocket.Conn]chan struct{}),
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "session"})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc(heartbeatPath, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case d.heartbeats <- struct{}{}:
+		default:
+		}
+		w.Write([]byte(`{"SESSION":true,"PING":true}`))
+	})
+	mux.HandleFunc("/ws/", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		refuse := d.refuse
+		d.mu.Unlock()
+
+		select {
+		case d.handshakes <- r:
+		default:
+		}
+		if refuse {
+			http.Error(w, "websocket refused", http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade websocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		dropCh := make(chan struct{})
+		d.mu.Lock()
+		d.conns[conn] = dropCh
+		d.mu.Unlock()
+		defer func() {
+			d.mu.Lock()
+			delete(d.conns, conn)
+			d.mu.Unlock()
+		}()
+
+		// Wait for the subscription before sending any frame.
+		var cr connectRequest
+		_, m, err := conn.ReadMessage()
+		if err == nil {
+			err = unmarshalWS(m, &cr)
+		}
+		if err != nil {
+			t.Errorf("failed to read subscription: %v", err)
+			return
+		}
+		d.requests <- cr
+
+		go func() {
+			for {
+				var cr connectRequest
+				_, m, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := unmarshalWS(m, &cr); err != nil {
+					t.Errorf("failed to decode subscription: %v", err)
+					return
+				}
+				d.requests <- cr
+			}
+		}()
+
+		for {
+			select {
+			case f, ok := <-d.frames:
+				if !ok {
+					return
+				}
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(f)); err != nil {
+					return
+				}
+			case <-dropCh:
+				return
+			}
+		}
+	})
+
+	d.Server = httptest.NewUnstartedServer(mux)
+	return d
+}
+
+// send sends a JSON document to a connected stats websocket.
+func (d *testDevice) send(doc string) {
+	d.frames <- strconv.Itoa(len(doc)) + "\n" + doc
+}
+
+// drop closes the open stats websockets, and makes the following
+// handshakes fail if refuse is true.
+func (d *testDevice) drop(refuse bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.refuse = refuse
+	for conn, dropCh := range d.conns {
+		conn.Close()
+		close(dropCh)
+		delete(d.conns, conn)
+	}
+}
+
+// accept makes the following stats websocket handshakes succeed again.
+func (d *testDevice) accept() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refuse = false
+}
+
+// Close stops the testDevice.
+func (d *testDevice) Close() {
+	close(d.frames)
+	d.Server.Close()
+}
+
+// newTestClient creates a Client logged in to a testDevice.
+func newTestClient(t *testing.T, d *testDevice, options ...Option) *Client {
+	c, err := NewClient(d.URL, d.Client(), options...)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := c.Login("ubnt", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	return c
+}
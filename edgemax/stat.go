@@ -1,23 +1,78 @@
 package edgemax
 
 // SystemStat is a stat which contains system statistics for an EdgeMAX device.
+// CPU and Mem are usage percentages, and Uptime is in seconds. Fields other
+// than these are only reported by some models and firmware versions.
 type SystemStat struct {
-	CPU    string `json:"cpu"`
-	Uptime string `json:"uptime"`
-	Mem    string `json:"mem"`
+	CPU    Float64 `json:"cpu"`
+	Uptime Uint64  `json:"uptime"`
+	Mem    Float64 `json:"mem"`
+
+	// CPUCores contains the usage percentage of each CPU core.
+	CPUCores []Float64 `json:"cpu_cores"`
+	// Memory contains the breakdown of memory usage.
+	Memory *MemoryStat `json:"memory"`
+	// Temperatures contains the temperature of each sensor, in degrees
+	// Celsius, indexed by sensor name.
+	Temperatures map[string]Float64 `json:"temps"`
+}
+
+// MemoryStat contains the memory usage of an EdgeMAX device, in kibibytes.
+type MemoryStat struct {
+	Total   Uint64 `json:"total"`
+	Free    Uint64 `json:"free"`
+	Buffers Uint64 `json:"buffers"`
+	Cached  Uint64 `json:"cached"`
+}
+
+// SystemInfo describes an EdgeMAX device.
+type SystemInfo struct {
+	Hostname string `json:"host_name"`
+	Model    string `json:"model"`
+	Firmware string `json:"sw_ver"`
 }
 
-// DPIStat contains Deep Packet Inspection stats from an EdgeMAX device.
-type DPIStat map[string]map[string]struct {
-	RXBytes string `json:"rx_bytes"`
-	TXBytes string `json:"tx_bytes"`
+// DPIStat contains Deep Packet Inspection stats from an EdgeMAX device,
+// indexed by client IP address and then by "type|category".
+type DPIStat map[string]map[string]DPITraffic
+
+// DPITraffic contains the traffic of a client for one kind of application.
+type DPITraffic struct {
+	RXBytes Uint64 `json:"rx_bytes"`
+	TXBytes Uint64 `json:"tx_bytes"`
+}
+
+// InterfaceStat contains network interface data transmission statistics,
+// indexed by interface name.
+type InterfacesStat map[string]Interface
+
+// Interface contains the state and statistics of one network interface.
+// Speed is in megabits per second, but is left as reported since devices
+// use other values for interfaces without a physical link.
+type Interface struct {
+	Up        Bool           `json:"up"`
+	L1Up      Bool           `json:"l1up"`
+	Autoneg   Bool           `json:"autoneg"`
+	Speed     string         `json:"speed"`
+	Duplex    string         `json:"duplex"`
+	Mac       string         `json:"mac"`
+	MTU       string         `json:"mtu"`
+	Addresses []string       `json:"addresses"`
+	Stats     InterfaceStats `json:"stats"`
 }
 
-// InterfaceStat contains network interface data transmission statistics.
-type InterfacesStat map[string]struct {
-	Mac   string `json:"mac"`
-	Stats struct {
-		RXBytes string `json:"rx_bytes"`
-		TXBytes string `json:"tx_bytes"`
-	} `json:"stats"`
+// InterfaceStats contains data transmission statistics for a network
+// interface. Rates are in bits per second.
+type InterfaceStats struct {
+	RXPackets Uint64  `json:"rx_packets"`
+	TXPackets Uint64  `json:"tx_packets"`
+	RXBytes   Uint64  `json:"rx_bytes"`
+	TXBytes   Uint64  `json:"tx_bytes"`
+	RXErrors  Uint64  `json:"rx_errors"`
+	TXErrors  Uint64  `json:"tx_errors"`
+	RXDropped Uint64  `json:"rx_dropped"`
+	TXDropped Uint64  `json:"tx_dropped"`
+	Multicast Uint64  `json:"multicast"`
+	RXBPS     Float64 `json:"rx_bps"`
+	TXBPS     Float64 `json:"tx_bps"`
 }
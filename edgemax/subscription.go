@@ -0,0 +1,201 @@
+package edgemax
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Names of the statistics streams modeled by this package.
+const (
+	StreamSystem     = "system-stats"
+	StreamDPI        = "export"
+	StreamInterfaces = "interfaces"
+)
+
+// A Subscription selects a statistics stream of an EdgeMAX device, and
+// handles the messages received on it. Subscriptions are created using
+// the functions of this package, and passed to Client.Stats or
+// Session.Subscribe.
+//
+// Each Subscription handles messages from its own goroutine, so handlers
+// may block without stalling other subscriptions. See WithPolicy for how
+// messages are buffered meanwhile.
+type Subscription struct {
+	stream string
+	handle func(ctx context.Context, raw json.RawMessage) error
+
+	policy DeliveryPolicy
+	size   int
+}
+
+// Stream returns the name of the stream selected by the Subscription.
+func (s Subscription) Stream() string {
+	return s.stream
+}
+
+// RawStats subscribes to an arbitrary stream, which fn receives as raw JSON.
+// It can be used for streams this package does not model yet.
+func RawStats(stream string, fn func(json.RawMessage)) Subscription {
+	if fn == nil {
+		return Subscription{stream: stream}
+	}
+
+	return Subscription{
+		stream: stream,
+		handle: func(_ context.Context, raw json.RawMessage) error {
+			fn(raw)
+			return nil
+		},
+	}
+}
+
+// SystemStats subscribes to the system statistics stream, which fn receives.
+func SystemStats(fn func(SystemStat)) Subscription {
+	if fn == nil {
+		return Subscription{stream: StreamSystem}
+	}
+
+	return Subscription{
+		stream: StreamSystem,
+		handle: func(_ context.Context, raw json.RawMessage) error {
+			var s SystemStat
+			if err := decode(StreamSystem, raw, &s); err != nil {
+				return err
+			}
+			fn(s)
+			return nil
+		},
+	}
+}
+
+// SystemStatsChan subscribes to the system statistics stream, which is sent
+// on ch.
+func SystemStatsChan(ch chan<- SystemStat) Subscription {
+	if ch == nil {
+		return Subscription{stream: StreamSystem}
+	}
+
+	return Subscription{
+		stream: StreamSystem,
+		handle: func(ctx context.Context, raw json.RawMessage) error {
+			var s SystemStat
+			if err := decode(StreamSystem, raw, &s); err != nil {
+				return err
+			}
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	}
+}
+
+// DPIStats subscribes to the deep packet inspection statistics stream,
+// which fn receives.
+func DPIStats(fn func(DPIStat)) Subscription {
+	if fn == nil {
+		return Subscription{stream: StreamDPI}
+	}
+
+	return Subscription{
+		stream: StreamDPI,
+		handle: func(_ context.Context, raw json.RawMessage) error {
+			var s DPIStat
+			if err := decode(StreamDPI, raw, &s); err != nil {
+				return err
+			}
+			fn(s)
+			return nil
+		},
+	}
+}
+
+// DPIStatsChan subscribes to the deep packet inspection statistics stream,
+// which is sent on ch.
+func DPIStatsChan(ch chan<- DPIStat) Subscription {
+	if ch == nil {
+		return Subscription{stream: StreamDPI}
+	}
+
+	return Subscription{
+		stream: StreamDPI,
+		handle: func(ctx context.Context, raw json.RawMessage) error {
+			var s DPIStat
+			if err := decode(StreamDPI, raw, &s); err != nil {
+				return err
+			}
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	}
+}
+
+// InterfacesStats subscribes to the network interfaces statistics stream,
+// which fn receives.
+func InterfacesStats(fn func(InterfacesStat)) Subscription {
+	if fn == nil {
+		return Subscription{stream: StreamInterfaces}
+	}
+
+	return Subscription{
+		stream: StreamInterfaces,
+		handle: func(_ context.Context, raw json.RawMessage) error {
+			var s InterfacesStat
+			if err := decode(StreamInterfaces, raw, &s); err != nil {
+				return err
+			}
+			fn(s)
+			return nil
+		},
+	}
+}
+
+// InterfacesStatsChan subscribes to the network interfaces statistics
+// stream, which is sent on ch.
+func InterfacesStatsChan(ch chan<- InterfacesStat) Subscription {
+	if ch == nil {
+		return Subscription{stream: StreamInterfaces}
+	}
+
+	return Subscription{
+		stream: StreamInterfaces,
+		handle: func(ctx context.Context, raw json.RawMessage) error {
+			var s InterfacesStat
+			if err := decode(StreamInterfaces, raw, &s); err != nil {
+				return err
+			}
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	}
+}
+
+// decode unmarshals a message of a stream into v. Messages which are not
+// valid JSON or contain a malformed stat are rejected with a *DecodeError,
+// rather than handled with zero values.
+func decode(stream string, raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &DecodeError{Stream: stream, Err: err}
+	}
+	if field, err := malformed(v); err != nil {
+		return &DecodeError{Stream: stream, Field: field, Err: err}
+	}
+	return nil
+}
+
+// validate checks that all subscriptions can handle messages.
+func validate(subs []Subscription) error {
+	for _, s := range subs {
+		if s.stream == "" || s.handle == nil {
+			return ErrInvalidSubscription
+		}
+	}
+	return nil
+}
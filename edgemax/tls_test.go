@@ -0,0 +1,135 @@
+package edgemax
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseFingerprint(t *testing.T) {
+	var tests = []struct {
+		desc string
+		in   string
+		ok   bool
+	}{
+		{
+			desc: "colon-separated",
+			in:   "E3:B0:C4:42:98:FC:1C:14:9A:FB:F4:C8:99:6F:B9:24:27:AE:41:E4:64:9B:93:4C:A4:95:99:1B:78:52:B8:55",
+			ok:   true,
+		},
+		{
+			desc: "lowercase with prefix",
+			in:   "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			ok:   true,
+		},
+		{
+			desc: "too short",
+			in:   "E3:B0:C4:42",
+		},
+		{
+			desc: "not hexadecimal",
+			in:   "not a fingerprint",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		b, err := ParseFingerprint(tt.in)
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err == nil && b[0] != 0xe3 {
+			t.Fatalf("unexpected fingerprint: %x", b)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	d := newTestDevice(t)
+	defer d.Close()
+
+	cert := d.Certificate()
+	fingerprint := sha256.Sum256(cert.Raw)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	var tests = []struct {
+		desc     string
+		tc       func() *tls.Config
+		mismatch bool
+		ok       bool
+	}{
+		{
+			desc: "unknown authority",
+			tc:   func() *tls.Config { return &tls.Config{} },
+		},
+		{
+			desc: "CA bundle",
+			tc:   func() *tls.Config { return &tls.Config{RootCAs: roots} },
+			ok:   true,
+		},
+		{
+			desc: "pinned certificate",
+			tc: func() *tls.Config {
+				tc := &tls.Config{}
+				PinCertificate(tc, fingerprint[:])
+				return tc
+			},
+			ok: true,
+		},
+		{
+			desc: "pin mismatch",
+			tc: func() *tls.Config {
+				tc := &tls.Config{RootCAs: roots}
+				PinCertificate(tc, make([]byte, sha256.Size))
+				return tc
+			},
+			mismatch: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		// The websocket is dialed with a logged in client, so that it
+		// is checked separately from HTTPS requests.
+		hc := *d.Client()
+		c, err := NewClient(d.URL, &hc)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		if err := c.Login("ubnt", "secret"); err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+		hc.Transport = &http.Transport{TLSClientConfig: tt.tc()}
+
+		for _, op := range []struct {
+			name string
+			do   func() error
+		}{
+			{name: "login", do: func() error { return c.Login("ubnt", "secret") }},
+			{name: "websocket", do: func() error {
+				conn, err := c.connect(context.Background(), []string{"system-stats"})
+				if err == nil {
+					conn.Close()
+				}
+				return err
+			}},
+		} {
+			err := op.do()
+			if want, got := tt.ok, err == nil; want != got {
+				t.Fatalf("unexpected %s error: %v", op.name, err)
+			}
+
+			var fe *FingerprintError
+			if want, got := tt.mismatch, errors.As(err, &fe); want != got {
+				t.Fatalf("unexpected %s fingerprint error:\n- want: %v\n-  got: %v", op.name, want, err)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
 )
 
@@ -17,6 +18,15 @@ type connectRequest struct {
 	SessionID   string `json:"SESSION_ID"`
 }
 
+// stats converts stream names to stat values.
+func stats(streams []string) []stat {
+	ss := make([]stat, 0, len(streams))
+	for _, s := range streams {
+		ss = append(ss, stat{Name: s})
+	}
+	return ss
+}
+
 func marshalWS(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -27,19 +37,117 @@ func marshalWS(v interface{}) []byte {
 	return append(blen, b...)
 }
 
-func unmarshalWS(data []byte, v interface{}) error {
-	if data[0] == '{' {
-		return json.Unmarshal(data, v)
+// maxFrameSize is the maximum length of a frame accepted by a frameDecoder.
+const maxFrameSize = 64 << 20
+
+// A frameDecoder reassembles the length-prefixed frames sent by EdgeMAX
+// devices on the stats websocket. A frame is a decimal length followed by a
+// newline and a JSON document of that many bytes, or a bare JSON document,
+// and may be split across several websocket messages, or share one with
+// other frames.
+type frameDecoder struct {
+	buf []byte
+}
+
+// decode appends a websocket message to the data received so far, and
+// returns the JSON documents of all frames it completes. On error, the
+// buffered data is discarded so that decoding can resume with the next
+// message.
+func (d *frameDecoder) decode(m []byte) ([][]byte, error) {
+	d.buf = append(d.buf, m...)
+
+	var docs [][]byte
+	for {
+		d.buf = bytes.TrimLeft(d.buf, " \t\r\n")
+		if len(d.buf) == 0 {
+			d.buf = nil
+			return docs, nil
+		}
+
+		doc, n, err := nextFrame(d.buf)
+		if err != nil {
+			d.buf = nil
+			return docs, err
+		}
+		if n == 0 {
+			// Incomplete frame, wait for the next message.
+			return docs, nil
+		}
+
+		docs = append(docs, doc)
+		d.buf = d.buf[n:]
 	}
+}
 
-	bb := bytes.SplitN(data, []byte("\n"), 2)
-	if l := len(bb); l != 2 {
-		return fmt.Errorf("incorrect number of elements in websocket message: %d", l)
+// nextFrame parses the frame at the beginning of b. It returns the JSON
+// document of the frame and the number of bytes it spans, or zero bytes if
+// b does not hold a complete frame yet.
+func nextFrame(b []byte) ([]byte, int, error) {
+	// Some messages carry a bare JSON document without a length, whose
+	// end is found by decoding it.
+	if b[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		var doc json.RawMessage
+		switch err := dec.Decode(&doc); {
+		case err == io.ErrUnexpectedEOF && len(b) <= maxFrameSize:
+			return nil, 0, nil
+		case err == io.ErrUnexpectedEOF:
+			return nil, 0, fmt.Errorf("JSON document without length exceeds maximum of %d bytes", maxFrameSize)
+		case err != nil:
+			return nil, 0, fmt.Errorf("invalid JSON document without length: %v", err)
+		}
+		n := int(dec.InputOffset())
+		return b[:n], n, nil
 	}
 
-	if len(bb[1]) == 0 {
-		return nil
+	i := bytes.IndexByte(b, '\n')
+	if i == -1 {
+		if len(b) > len(strconv.Itoa(maxFrameSize)) || !isDigits(b) {
+			return nil, 0, fmt.Errorf("invalid frame length: %q", truncate(b))
+		}
+		return nil, 0, nil
 	}
 
-	return json.Unmarshal(bb[1], v)
+	l := bytes.TrimRight(b[:i], "\r")
+	if !isDigits(l) {
+		return nil, 0, fmt.Errorf("invalid frame length: %q", truncate(l))
+	}
+	n, err := strconv.Atoi(string(l))
+	if err != nil || n > maxFrameSize {
+		return nil, 0, fmt.Errorf("frame length %s exceeds maximum of %d bytes", l, maxFrameSize)
+	}
+
+	end := i + 1 + n
+	if len(b) < end {
+		return nil, 0, nil
+	}
+
+	doc := b[i+1 : end]
+	if !json.Valid(doc) {
+		return nil, 0, fmt.Errorf("frame length %d does not match a JSON document: %q", n, truncate(doc))
+	}
+
+	return doc, end, nil
+}
+
+// isDigits reports whether b is a non-empty string of decimal digits.
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// truncate shortens b for use in error messages.
+func truncate(b []byte) []byte {
+	const max = 32
+	if len(b) > max {
+		return b[:max]
+	}
+	return b
 }
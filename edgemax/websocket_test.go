@@ -2,7 +2,9 @@ package edgemax
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -51,6 +53,25 @@ func TestMarshalWS(t *testing.T) {
 	}
 }
 
+// unmarshalWS decodes a websocket message holding a single frame, as sent by
+// a Client, on behalf of the test devices.
+func unmarshalWS(data []byte, v interface{}) error {
+	if data[0] == '{' {
+		return json.Unmarshal(data, v)
+	}
+
+	bb := bytes.SplitN(data, []byte("\n"), 2)
+	if l := len(bb); l != 2 {
+		return fmt.Errorf("incorrect number of elements in websocket message: %d", l)
+	}
+
+	if len(bb[1]) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(bb[1], v)
+}
+
 func TestUnmarshalWS(t *testing.T) {
 	var tests = []struct {
 		desc string
@@ -125,3 +146,137 @@ func errStr(err error) string {
 	}
 	return err.Error()
 }
+
+func TestFrameDecoder(t *testing.T) {
+	var tests = []struct {
+		desc string
+		in   []string
+		out  []string
+		err  error
+	}{
+		{
+			desc: "single frame",
+			in:   []string{"9\n{\"a\":\"b\"}"},
+			out:  []string{`{"a":"b"}`},
+		},
+		{
+			desc: "bare JSON document",
+			in:   []string{`{"a":"b"}`},
+			out:  []string{`{"a":"b"}`},
+		},
+		{
+			desc: "empty message",
+			in:   []string{""},
+		},
+		{
+			desc: "frame split across messages",
+			in:   []string{"9", "\n{\"a\"", ":\"b\"}"},
+			out:  []string{`{"a":"b"}`},
+		},
+		{
+			desc: "several frames in one message",
+			in:   []string{"9\n{\"a\":\"b\"}\n7\n{\"c\":1}"},
+			out:  []string{`{"a":"b"}`, `{"c":1}`},
+		},
+		{
+			desc: "frames joined and split",
+			in:   []string{"9\n{\"a\":\"b\"}7\n{\"c\"", ":1}2\n{}"},
+			out:  []string{`{"a":"b"}`, `{"c":1}`, `{}`},
+		},
+		{
+			desc: "CRLF after length",
+			in:   []string{"2\r\n{}"},
+			out:  []string{`{}`},
+		},
+		{
+			desc: "incomplete frame",
+			in:   []string{"10\n{\"a\":\"b\"}"},
+		},
+		{
+			desc: "non-numeric length",
+			in:   []string{"foo\n{}"},
+			err:  errors.New(`invalid frame length: "foo"`),
+		},
+		{
+			desc: "non-numeric length without newline",
+			in:   []string{"foo"},
+			err:  errors.New(`invalid frame length: "foo"`),
+		},
+		{
+			desc: "length too large",
+			in:   []string{"99999999999\n{}"},
+			err:  errors.New("frame length 99999999999 exceeds maximum of 67108864 bytes"),
+		},
+		{
+			desc: "length too short",
+			in:   []string{"8\n{\"a\":\"b\"}"},
+			err:  errors.New(`frame length 8 does not match a JSON document: "{\"a\":\"b\""`),
+		},
+		{
+			desc: "length too long",
+			in:   []string{"10\n{\"a\":\"b\"}2\n{}"},
+			err:  errors.New(`frame length 10 does not match a JSON document: "{\"a\":\"b\"}2"`),
+		},
+		{
+			desc: "bare JSON document split across messages",
+			in:   []string{`{"a"`, `:"b}"`, `}`},
+			out:  []string{`{"a":"b}"}`},
+		},
+		{
+			desc: "bare JSON document followed by frame",
+			in:   []string{"{\"a\":\"b\"}2\n{}"},
+			out:  []string{`{"a":"b"}`, `{}`},
+		},
+		{
+			desc: "incomplete bare JSON document",
+			in:   []string{`{"a":`},
+		},
+		{
+			desc: "invalid bare JSON document",
+			in:   []string{`{"a":]`},
+			err:  errors.New("invalid JSON document without length: invalid character ']' looking for beginning of value"),
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var (
+			d   frameDecoder
+			out []string
+			err error
+		)
+		for _, m := range tt.in {
+			var docs [][]byte
+			docs, err = d.decode([]byte(m))
+			for _, doc := range docs {
+				out = append(out, string(doc))
+			}
+		}
+
+		if want, got := errStr(tt.err), errStr(err); want != got {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := tt.out, out; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected documents:\n- want: %q\n-  got: %q", want, got)
+		}
+		if err != nil && len(d.buf) != 0 {
+			t.Fatalf("decoder kept %d bytes after error", len(d.buf))
+		}
+	}
+}
+
+func TestFrameDecoderResync(t *testing.T) {
+	var d frameDecoder
+	if _, err := d.decode([]byte("foo\n")); err == nil {
+		t.Fatal("expected an error for an invalid frame")
+	}
+
+	docs, err := d.decode([]byte("2\n{}"))
+	if err != nil {
+		t.Fatalf("unexpected error after resync: %v", err)
+	}
+	if want, got := 1, len(docs); want != got {
+		t.Fatalf("unexpected number of documents:\n- want: %d\n-  got: %d", want, got)
+	}
+}
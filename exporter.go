@@ -1,7 +1,11 @@
 package edgemax_exporter
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vaga/edgemax_exporter/edgemax"
@@ -9,40 +13,184 @@ import (
 
 // Exporter is a Prometheus exporter for Ubiquiti UniFi Controller API
 // metrics. It wraps all UniFi metrics collectors and provides a single global
-// exporter which can serve metrics. It also ensures that the collection
-// is done in a thread-safe manner, the necessary requirement stated by
-// Prometheus. It implements the prometheus.Collector interface in order to
-// register with Prometheus.
+// exporter which can serve metrics. Each collector keeps the latest stats
+// received from the device, and builds metrics from them at scrape time, so
+// that collection is thread-safe and every scrape is consistent, as required
+// by Prometheus. It implements the prometheus.Collector interface in order
+// to register with Prometheus.
 type Exporter struct {
-	mu         sync.Mutex
 	collectors []prometheus.Collector
+	session    *edgemax.Session
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	seriesTTL    time.Duration
+	legacyGauges bool
+	enabled      []string
+}
+
+// An Option configures optional behavior of an Exporter.
+type Option func(e *Exporter)
+
+// WithSeriesTTL sets the duration after which series of interfaces and deep
+// packet inspection clients which are no longer reported by the device are
+// removed. A zero duration only keeps the series of the latest stats.
+func WithSeriesTTL(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.seriesTTL = d
+	}
+}
+
+// Names of the collectors of an Exporter, which can be selected using
+// WithCollectors.
+const (
+	CollectorSystem     = "system"
+	CollectorInterfaces = "interfaces"
+	CollectorDPI        = "dpi"
+)
+
+// WithCollectors only enables the collectors with the specified names, and
+// only subscribes to their streams. All collectors are enabled by default.
+func WithCollectors(names ...string) Option {
+	return func(e *Exporter) {
+		e.enabled = names
+	}
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
 var _ prometheus.Collector = &Exporter{}
 
-// namespace is the top-level namespace for this UniFi exporter.
-const namespace = "edgemax"
+const (
+	// namespace is the top-level namespace for this UniFi exporter.
+	namespace = "edgemax"
+	// systemInfoInterval is the interval between two retrievals of the
+	// device information.
+	systemInfoInterval = 15 * time.Minute
+)
+
+// WithLegacyGauges also exports byte counts using the gauges which were used
+// before they were exposed as counters, such as
+// edgemax_interfaces_received_bytes, to ease migration.
+func WithLegacyGauges(enabled bool) Option {
+	return func(e *Exporter) {
+		e.legacyGauges = enabled
+	}
+}
 
 // New creates a new Exporter which collects metrics from one or mote sites.
-func New(client *edgemax.Client) (*Exporter, func(), error) {
+// Options may be specified to further configure the Exporter.
+// Exporter.Close must be called to stop retrieving statistics.
+func New(client *edgemax.Client, options ...Option) (*Exporter, error) {
+	e := &Exporter{
+		seriesTTL: defaultSeriesTTL,
+		enabled:   []string{CollectorSystem, CollectorInterfaces, CollectorDPI},
+	}
+	for _, o := range options {
+		o(e)
+	}
+
+	enabled := make(map[string]bool, len(e.enabled))
+	for _, name := range e.enabled {
+		switch name {
+		case CollectorSystem, CollectorInterfaces, CollectorDPI:
+			enabled[name] = true
+		default:
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	expired := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "expired_series_total",
+			Help:      "Number of series removed because the device stopped reporting them, partitioned by collector",
+		},
+		[]string{"collector"},
+	)
 
-	systemCh := make(chan edgemax.SystemStat)
-	dpiCh := make(chan edgemax.DPIStat)
-	ifacesCh := make(chan edgemax.InterfacesStat)
+	var (
+		system = newSystemCollector()
+		subs   []edgemax.Subscription
+	)
+	if enabled[CollectorSystem] {
+		subs = append(subs, edgemax.SystemStats(system.update))
+		e.collectors = append(e.collectors, system)
+	}
+	if enabled[CollectorDPI] {
+		dpi := newDPICollector(e.seriesTTL, expired.WithLabelValues(CollectorDPI), e.legacyGauges)
+		subs = append(subs, edgemax.DPIStats(dpi.update))
+		e.collectors = append(e.collectors, dpi)
+	}
+	if enabled[CollectorInterfaces] {
+		ifaces := newInterfacesCollector(e.seriesTTL, expired.WithLabelValues(CollectorInterfaces), e.legacyGauges)
+		subs = append(subs, edgemax.InterfacesStats(ifaces.update))
+		e.collectors = append(e.collectors, ifaces)
+	}
+	e.collectors = append(e.collectors, expired)
 
-	done, err := client.Stats(systemCh, dpiCh, ifacesCh)
+	session, err := client.Stats(subs...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	e.session = session
 
-	return &Exporter{
-		collectors: []prometheus.Collector{
-			newSystemCollector(systemCh),
-			newDPICollector(dpiCh),
-			newInterfacesCollector(ifacesCh),
-		},
-	}, done, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+
+	if enabled[CollectorSystem] {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.systemInfo(ctx, client, system)
+		}()
+	}
+
+	return e, nil
+}
+
+// Close stops retrieving statistics from the EdgeMAX device.
+func (e *Exporter) Close() error {
+	e.cancel()
+	e.wg.Wait()
+
+	return e.session.Close()
+}
+
+// Done returns a channel which is closed once the Exporter has stopped
+// retrieving statistics, because it was closed or its session failed.
+func (e *Exporter) Done() <-chan struct{} {
+	return e.session.Done()
+}
+
+// Err returns nil while the Exporter is running. After Done is closed, it
+// returns the error which made its session fail, as edgemax.Session.Err.
+func (e *Exporter) Err() error {
+	return e.session.Err()
+}
+
+// systemInfo retrieves information about the device at regular intervals,
+// since its firmware may be upgraded while the exporter is running.
+func (e *Exporter) systemInfo(ctx context.Context, client *edgemax.Client, c *systemCollector) {
+	t := time.NewTicker(systemInfoInterval)
+	defer t.Stop()
+
+	for {
+		si, err := client.SystemInfoContext(ctx)
+		switch {
+		case err == nil:
+			c.updateInfo(si)
+		case ctx.Err() == nil:
+			log.Printf("could not retrieve system information: %v", err)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 // Describe sends all the descriptors of the collectors included to
@@ -54,11 +202,10 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 }
 
 // Collect sends the collected metrics from each of the collectors to
-// prometheus. Collect could be called several times concurrently
-// and thus its run is protected by a single mutex.
+// prometheus. Collect could be called several times concurrently: the
+// system collector reads its latest stats atomically, while the other
+// collectors lock their series cache until their metrics are sent.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
 	for _, c := range e.collectors {
 		c.Collect(ch)
 	}
@@ -1,7 +1,10 @@
 package edgemax_exporter
 
 import (
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vaga/edgemax_exporter/edgemax"
@@ -10,83 +13,216 @@ import (
 // A interfacesCollector is a Prometheus collector for metrics regarding Ubiquiti
 // UniFi devices.
 type interfacesCollector struct {
-	receivedBytes    *prometheus.GaugeVec
-	transmittedBytes *prometheus.GaugeVec
+	counters []interfaceCounter
+
+	receiveRate  *prometheus.Desc
+	transmitRate *prometheus.Desc
+	up           *prometheus.Desc
+	linkUp       *prometheus.Desc
+	linkSpeed    *prometheus.Desc
+	info         *prometheus.Desc
+
+	// Gauges exported before byte counts were exposed as counters, kept
+	// during migration if legacy is set.
+	legacy           bool
+	receivedBytes    *prometheus.Desc
+	transmittedBytes *prometheus.Desc
+
+	series *seriesCache
+}
+
+// An interfaceCounter is a counter built from a field of
+// edgemax.InterfaceStats.
+type interfaceCounter struct {
+	desc  *prometheus.Desc
+	value func(s edgemax.InterfaceStats) edgemax.Uint64
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
 var _ prometheus.Collector = &interfacesCollector{}
 
 // newInterfacesCollector creates a new interfacesCollector which collects metrics for
-// a specified site.
-func newInterfacesCollector(ch <-chan edgemax.InterfacesStat) *interfacesCollector {
-	const subsystem = "interfaces"
+// a specified site. Interfaces which are no longer reported are removed after
+// ttl, and counted using expired. If legacy is set, byte counts are also
+// exported using the former gauges.
+func newInterfacesCollector(ttl time.Duration, expired prometheus.Counter, legacy bool) *interfacesCollector {
+	const (
+		subsystem       = "interface"
+		legacySubsystem = "interfaces"
+	)
 	labels := []string{"name", "mac"}
 
-	c := &interfacesCollector{
-		receivedBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Subsystem: subsystem,
-				Name:      "received_bytes",
-				Help:      "Number of bytes received by interfaces, partitioned by network interface",
-			},
-			labels,
-		),
-		transmittedBytes: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Subsystem: subsystem,
-				Name:      "transmitted_bytes",
-				Help:      "Number of bytes transmitted by interfaces, partitioned by network interface",
-			},
-			labels,
-		),
+	counter := func(name, help string, value func(s edgemax.InterfaceStats) edgemax.Uint64) interfaceCounter {
+		return interfaceCounter{
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, subsystem, name),
+				help,
+				labels, nil,
+			),
+			value: value,
+		}
 	}
 
-	go c.collect(ch)
+	return &interfacesCollector{
+		counters: []interfaceCounter{
+			counter("receive_bytes_total", "Number of bytes received by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.RXBytes }),
+			counter("transmit_bytes_total", "Number of bytes transmitted by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.TXBytes }),
+			counter("receive_packets_total", "Number of packets received by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.RXPackets }),
+			counter("transmit_packets_total", "Number of packets transmitted by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.TXPackets }),
+			counter("receive_errors_total", "Number of receive errors on interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.RXErrors }),
+			counter("transmit_errors_total", "Number of transmit errors on interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.TXErrors }),
+			counter("receive_drops_total", "Number of received packets dropped by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.RXDropped }),
+			counter("transmit_drops_total", "Number of transmitted packets dropped by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.TXDropped }),
+			counter("multicast_packets_total", "Number of multicast packets received by interfaces, partitioned by network interface",
+				func(s edgemax.InterfaceStats) edgemax.Uint64 { return s.Multicast }),
+		},
 
-	return c
-}
-
-// collect begins a metrics collection task for all metrics related to UniFi
-// devices.
-func (c *interfacesCollector) collect(ch <-chan edgemax.InterfacesStat) {
-	for s := range ch {
-		for name, iface := range s {
-			labels := []string{name, iface.Mac}
+		receiveRate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "receive_bits_per_second"),
+			"Receive rate of interfaces as computed by the device, partitioned by network interface",
+			labels, nil,
+		),
+		transmitRate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "transmit_bits_per_second"),
+			"Transmit rate of interfaces as computed by the device, partitioned by network interface",
+			labels, nil,
+		),
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "up"),
+			"Whether interfaces are administratively up, partitioned by network interface",
+			labels, nil,
+		),
+		linkUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "link_up"),
+			"Whether interfaces have a physical link, partitioned by network interface",
+			labels, nil,
+		),
+		linkSpeed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "link_speed_bits_per_second"),
+			"Negotiated link speed of interfaces, partitioned by network interface",
+			labels, nil,
+		),
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "info"),
+			"Configuration of interfaces, partitioned by network interface",
+			append(labels, "mtu", "duplex", "addresses"), nil,
+		),
 
-			rxBytes, _ := strconv.Atoi(iface.Stats.RXBytes)
-			c.receivedBytes.WithLabelValues(labels...).Set(float64(rxBytes))
+		legacy: legacy,
+		receivedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, legacySubsystem, "received_bytes"),
+			"Deprecated: use edgemax_interface_receive_bytes_total",
+			labels, nil,
+		),
+		transmittedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, legacySubsystem, "transmitted_bytes"),
+			"Deprecated: use edgemax_interface_transmit_bytes_total",
+			labels, nil,
+		),
 
-			txBytes, _ := strconv.Atoi(iface.Stats.TXBytes)
-			c.transmittedBytes.WithLabelValues(labels...).Set(float64(txBytes))
-		}
+		series: newSeriesCache(ttl, expired),
 	}
 }
 
-// collectors contains a list of collectors which are collected each time
-// the exporter is scraped. This list must be kept in sync with the collectors
-// in InterfacesCollector.
-func (c *interfacesCollector) collectors() []prometheus.Collector {
-	return []prometheus.Collector{
-		c.receivedBytes,
-		c.transmittedBytes,
+// update stores the latest interfaces stats, which are exported on the next
+// scrape.
+func (c *interfacesCollector) update(s edgemax.InterfacesStat) {
+	u := make(seriesUpdate, len(s))
+	for name, iface := range s {
+		counters := make([]edgemax.Uint64, 0, len(c.counters))
+		for _, ic := range c.counters {
+			counters = append(counters, ic.value(iface.Stats))
+		}
+
+		u.set(iface, counters, name, iface.Mac)
 	}
+	c.series.update(u)
 }
 
 // Describe sends the descriptors of each metric over to the provided channel.
 // The corresponding metric values are sent separately.
 func (c *interfacesCollector) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range c.collectors() {
-		m.Describe(ch)
+	ds := []*prometheus.Desc{
+		c.receiveRate,
+		c.transmitRate,
+		c.up,
+		c.linkUp,
+		c.linkSpeed,
+		c.info,
+	}
+	for _, ic := range c.counters {
+		ds = append(ds, ic.desc)
+	}
+	if c.legacy {
+		ds = append(ds, c.receivedBytes, c.transmittedBytes)
+	}
+
+	for _, d := range ds {
+		ch <- d
 	}
 }
 
 // Collect sends the metric values for each metric pertaining to the global
 // cluster usage over to the provided prometheus Metric channel.
 func (c *interfacesCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, m := range c.collectors() {
-		m.Collect(ch)
+	c.series.each(func(v interface{}, counters []deviceCounter, labels []string) {
+		iface := v.(edgemax.Interface)
+
+		for i, ic := range c.counters {
+			if counters[i].valid {
+				ch <- prometheus.MustNewConstMetric(ic.desc, prometheus.CounterValue, counters[i].total(), labels...)
+			}
+		}
+
+		if iface.Stats.RXBPS.Valid {
+			ch <- prometheus.MustNewConstMetric(c.receiveRate, prometheus.GaugeValue, iface.Stats.RXBPS.Value, labels...)
+		}
+		if iface.Stats.TXBPS.Valid {
+			ch <- prometheus.MustNewConstMetric(c.transmitRate, prometheus.GaugeValue, iface.Stats.TXBPS.Value, labels...)
+		}
+		if iface.Up.Valid {
+			ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, boolValue(iface.Up.Value), labels...)
+		}
+		if iface.L1Up.Valid {
+			ch <- prometheus.MustNewConstMetric(c.linkUp, prometheus.GaugeValue, boolValue(iface.L1Up.Value), labels...)
+		}
+
+		// Speed is not reported for interfaces without a physical link,
+		// such as bridges and tunnels.
+		if speed, err := strconv.ParseFloat(iface.Speed, 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.linkSpeed, prometheus.GaugeValue, speed*1e6, labels...)
+		}
+
+		addrs := append([]string(nil), iface.Addresses...)
+		sort.Strings(addrs)
+		infoLabels := append(append([]string(nil), labels...), iface.MTU, iface.Duplex, strings.Join(addrs, ","))
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, infoLabels...)
+
+		if !c.legacy {
+			return
+		}
+
+		if iface.Stats.RXBytes.Valid {
+			ch <- prometheus.MustNewConstMetric(c.receivedBytes, prometheus.GaugeValue, float64(iface.Stats.RXBytes.Value), labels...)
+		}
+		if iface.Stats.TXBytes.Valid {
+			ch <- prometheus.MustNewConstMetric(c.transmittedBytes, prometheus.GaugeValue, float64(iface.Stats.TXBytes.Value), labels...)
+		}
+	})
+}
+
+// boolValue exports a boolean stat as 1 or 0.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
 }
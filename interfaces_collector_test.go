@@ -0,0 +1,117 @@
+package edgemax_exporter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+func TestInterfacesCollector(t *testing.T) {
+	const in = `{
+		"eth0": {
+			"up": "true", "l1up": "true", "autoneg": "true", "speed": "1000", "duplex": "full",
+			"mac": "04:18:d6:00:00:01", "mtu": "1500", "addresses": ["192.0.2.1/24", "2001:db8::1/64"],
+			"stats": {
+				"rx_packets": "10", "tx_packets": "20", "rx_bytes": "1000", "tx_bytes": "2000",
+				"rx_errors": "1", "tx_errors": "2", "rx_dropped": "3", "tx_dropped": "4",
+				"multicast": "5", "rx_bps": "800", "tx_bps": "1600"
+			}
+		},
+		"br0": {
+			"up": "true", "l1up": "false", "mac": "04:18:d6:00:00:02", "mtu": "1500",
+			"stats": {"rx_bytes": "7"}
+		}
+	}`
+
+	var s edgemax.InterfacesStat
+	if err := json.Unmarshal([]byte(in), &s); err != nil {
+		t.Fatalf("failed to decode interfaces: %v", err)
+	}
+
+	c := newInterfacesCollector(time.Minute, prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}), false)
+	c.update(s)
+
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(c)
+
+	want := map[string]float64{
+		`edgemax_interface_receive_bytes_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                   1000,
+		`edgemax_interface_transmit_bytes_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                  2000,
+		`edgemax_interface_receive_packets_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                 10,
+		`edgemax_interface_transmit_packets_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                20,
+		`edgemax_interface_receive_errors_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                  1,
+		`edgemax_interface_transmit_errors_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                 2,
+		`edgemax_interface_receive_drops_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                   3,
+		`edgemax_interface_transmit_drops_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                                  4,
+		`edgemax_interface_multicast_packets_total{mac="04:18:d6:00:00:01",name="eth0"}`:                                               5,
+		`edgemax_interface_receive_bits_per_second{mac="04:18:d6:00:00:01",name="eth0"}`:                                               800,
+		`edgemax_interface_transmit_bits_per_second{mac="04:18:d6:00:00:01",name="eth0"}`:                                              1600,
+		`edgemax_interface_up{mac="04:18:d6:00:00:01",name="eth0"}`:                                                                    1,
+		`edgemax_interface_link_up{mac="04:18:d6:00:00:01",name="eth0"}`:                                                               1,
+		`edgemax_interface_link_speed_bits_per_second{mac="04:18:d6:00:00:01",name="eth0"}`:                                            1e9,
+		`edgemax_interface_info{addresses="192.0.2.1/24,2001:db8::1/64",duplex="full",mac="04:18:d6:00:00:01",mtu="1500",name="eth0"}`: 1,
+		`edgemax_interface_receive_bytes_total{mac="04:18:d6:00:00:02",name="br0"}`:                                                    7,
+		`edgemax_interface_up{mac="04:18:d6:00:00:02",name="br0"}`:                                                                     1,
+		`edgemax_interface_link_up{mac="04:18:d6:00:00:02",name="br0"}`:                                                                0,
+		`edgemax_interface_info{addresses="",duplex="",mac="04:18:d6:00:00:02",mtu="1500",name="br0"}`:                                 1,
+	}
+
+	got := gather(t, r)
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("unexpected value for %s:\n- want: %v\n-  got: %v", k, v, got[k])
+		}
+	}
+	for _, name := range []string{
+		"edgemax_interface_link_speed_bits_per_second",
+		"edgemax_interface_transmit_bytes_total",
+		"edgemax_interface_receive_packets_total",
+		"edgemax_interface_transmit_packets_total",
+		"edgemax_interface_receive_errors_total",
+		"edgemax_interface_transmit_errors_total",
+		"edgemax_interface_receive_drops_total",
+		"edgemax_interface_transmit_drops_total",
+		"edgemax_interface_multicast_packets_total",
+		"edgemax_interface_receive_bits_per_second",
+	} {
+		if v, ok := got[name+`{mac="04:18:d6:00:00:02",name="br0"}`]; ok {
+			t.Fatalf("unexpected %s for interface not reporting it: %v", name, v)
+		}
+	}
+}
+
+// gather collects all metrics of a registry, indexed by name and labels.
+func gather(t *testing.T, r *prometheus.Registry) map[string]float64 {
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	out := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			k := mf.GetName() + "{"
+			for i, l := range m.GetLabel() {
+				if i > 0 {
+					k += ","
+				}
+				k += l.GetName() + `="` + l.GetValue() + `"`
+			}
+			k += "}"
+
+			switch {
+			case m.Gauge != nil:
+				out[k] = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				out[k] = m.GetCounter().GetValue()
+			case m.Untyped != nil:
+				out[k] = m.GetUntyped().GetValue()
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,347 @@
+package edgemax_exporter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+// A Target is an EdgeMAX device which can be scraped through a Prober.
+type Target struct {
+	// Name identifies the target in the target parameter of probes.
+	Name string
+	// Address, Username and Password are used to log in to the device,
+	// with Client if it is not nil. If Credentials is not nil, it provides
+	// the username and password instead, each time the target logs in.
+	Address     string
+	Username    string
+	Password    string
+	Credentials edgemax.CredentialsProvider
+	Client      *http.Client
+
+	// Collectors contains the names of the collectors enabled for the
+	// target, or all collectors if it is empty.
+	Collectors []string
+	// Labels are added to all metrics of the target.
+	Labels map[string]string
+}
+
+// A Prober is an http.Handler which serves the metrics of one of several
+// EdgeMAX devices, selected by the target query parameter, in the style of
+// the blackbox and SNMP exporters. Each target keeps a long-lived
+// edgemax.Client and Exporter, started on its first probe, and its own
+// registry so that a probe only returns the metrics of its device.
+type Prober struct {
+	options []Option
+
+	mu      sync.Mutex
+	targets map[string]*probeTarget
+}
+
+// errTargetRemoved is returned when a target is probed while it is removed.
+var errTargetRemoved = errors.New("target was removed")
+
+const (
+	// minLoginBackoff and maxLoginBackoff bound the delay before a target
+	// attempts to log in again after a failure, so that probes with invalid
+	// credentials do not lock out the account on the device.
+	minLoginBackoff = 10 * time.Second
+	maxLoginBackoff = 10 * time.Minute
+)
+
+// A probeTarget holds the running state of a Target.
+type probeTarget struct {
+	Target
+	client   *edgemax.Client
+	registry *prometheus.Registry
+	// registerer adds the labels of the target to its metrics.
+	registerer prometheus.Registerer
+
+	// mu serializes logins, and protects exporter, which is set once the
+	// target is logged in and cleared once its session has ended, stopped,
+	// which is set once the target is removed, and the state of the login
+	// backoff.
+	mu       sync.Mutex
+	exporter *Exporter
+	stopped  bool
+
+	now      func() time.Time
+	loginErr error
+	delay    time.Duration
+	retry    time.Time
+}
+
+// NewProber creates a Prober for targets, whose Exporters are created with
+// options. Prober.Close must be called to stop retrieving statistics.
+func NewProber(targets []Target, options ...Option) (*Prober, error) {
+	p := &Prober{
+		options: options,
+		targets: make(map[string]*probeTarget, len(targets)),
+	}
+
+	for _, t := range targets {
+		if err := p.Add(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Add adds a target to the Prober. Its Exporter is started on its first
+// probe.
+func (p *Prober) Add(t Target) error {
+	return p.Replace(nil, []Target{t})
+}
+
+// Remove removes a target from the Prober, and stops its Exporter. It
+// returns the error which made the Exporter fail, if any.
+func (p *Prober) Remove(name string) error {
+	p.mu.Lock()
+	t, ok := p.targets[name]
+	delete(p.targets, name)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown target %q", name)
+	}
+	return t.stop()
+}
+
+// Replace removes the targets named remove and adds the targets of add, at
+// once: every new target is created and checked first, so that if any of
+// them cannot be added, the Prober is left unchanged. Errors of the
+// Exporters of removed targets are logged.
+func (p *Prober) Replace(remove []string, add []Target) error {
+	pts := make([]*probeTarget, 0, len(add))
+	for _, t := range add {
+		pt, err := newProbeTarget(t)
+		if err != nil {
+			return err
+		}
+		pts = append(pts, pt)
+	}
+
+	p.mu.Lock()
+	removed := make(map[string]*probeTarget, len(remove))
+	for _, name := range remove {
+		t, ok := p.targets[name]
+		if !ok {
+			p.mu.Unlock()
+			return fmt.Errorf("unknown target %q", name)
+		}
+		removed[name] = t
+	}
+	added := make(map[string]bool, len(pts))
+	for _, pt := range pts {
+		if _, ok := p.targets[pt.Name]; (ok && removed[pt.Name] == nil) || added[pt.Name] {
+			p.mu.Unlock()
+			return fmt.Errorf("duplicate target %q", pt.Name)
+		}
+		added[pt.Name] = true
+	}
+
+	for name := range removed {
+		delete(p.targets, name)
+	}
+	for _, pt := range pts {
+		p.targets[pt.Name] = pt
+	}
+	p.mu.Unlock()
+
+	for _, name := range remove {
+		if err := removed[name].stop(); err != nil {
+			log.Printf("target %q stopped with error: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// newProbeTarget creates the client and registry of a target.
+func newProbeTarget(t Target) (*probeTarget, error) {
+	cc := NewClientCollector()
+	options := []edgemax.Option{edgemax.WithObserver(cc)}
+	if t.Credentials != nil {
+		options = append(options, edgemax.WithCredentialsProvider(t.Credentials))
+	}
+
+	c, err := edgemax.NewClient(t.Address, t.Client, options...)
+	if err != nil {
+		return nil, fmt.Errorf("target %q: %v", t.Name, err)
+	}
+
+	r := prometheus.NewRegistry()
+	rr := prometheus.WrapRegistererWith(t.Labels, r)
+	if err := rr.Register(cc); err != nil {
+		return nil, fmt.Errorf("target %q: %v", t.Name, err)
+	}
+
+	return &probeTarget{
+		Target:     t,
+		client:     c,
+		registry:   r,
+		registerer: rr,
+		now:        time.Now,
+	}, nil
+}
+
+// Targets returns the names of the targets of the Prober, sorted.
+func (p *Prober) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.targets))
+	for name := range p.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServeHTTP implements http.Handler. Until a target is logged in, each probe
+// attempts to log in, and only the metrics regarding the health of its
+// client are served.
+func (p *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("target")
+	if name == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	t, ok := p.targets[name]
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+		return
+	}
+
+	if err := t.start(r, p.options); err != nil {
+		log.Printf("could not start exporter for target %q: %v", name, err)
+	}
+
+	promhttp.HandlerFor(t.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// start logs in to the device of a target and starts its Exporter, unless
+// it is already running. An Exporter whose session has ended, for example
+// because the password was changed on the device, is replaced. After a
+// failed login, probes do not log in again until a backoff delay has
+// elapsed.
+func (t *probeTarget) start(r *http.Request, options []Option) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return errTargetRemoved
+	}
+	if t.exporter != nil {
+		select {
+		case <-t.exporter.Done():
+		default:
+			return nil
+		}
+
+		err := t.exporter.Err()
+		t.registerer.Unregister(t.exporter)
+		t.exporter.Close()
+		t.exporter = nil
+
+		t.backOff(err)
+		return fmt.Errorf("session ended: %v", err)
+	}
+
+	if now := t.now(); now.Before(t.retry) {
+		return fmt.Errorf("not logging in again for %s after failure: %v", t.retry.Sub(now).Round(time.Second), t.loginErr)
+	}
+
+	username, password := t.Username, t.Password
+	if t.Credentials != nil {
+		var err error
+		if username, password, err = t.Credentials(); err != nil {
+			return fmt.Errorf("could not retrieve credentials: %v", err)
+		}
+	}
+	if err := t.client.LoginContext(r.Context(), username, password); err != nil {
+		t.backOff(err)
+		return err
+	}
+	t.delay = 0
+
+	if len(t.Collectors) > 0 {
+		options = append(options[:len(options):len(options)], WithCollectors(t.Collectors...))
+	}
+
+	e, err := New(t.client, options...)
+	if err != nil {
+		return err
+	}
+	if err := t.registerer.Register(e); err != nil {
+		e.Close()
+		return err
+	}
+
+	t.exporter = e
+	return nil
+}
+
+// backOff delays the next login after err, doubling the delay after each
+// consecutive failure. The caller must hold mu.
+func (t *probeTarget) backOff(err error) {
+	switch {
+	case t.delay == 0:
+		t.delay = minLoginBackoff
+	case t.delay < maxLoginBackoff:
+		t.delay *= 2
+		if t.delay > maxLoginBackoff {
+			t.delay = maxLoginBackoff
+		}
+	}
+
+	t.loginErr = err
+	t.retry = t.now().Add(t.delay)
+}
+
+// Close stops the Exporters of all targets. It returns the first error
+// which made one of them fail, if any.
+func (p *Prober) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for _, t := range p.targets {
+		if cerr := t.stop(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// stop stops the Exporter of a target and logs out, if it is running, and
+// prevents probes from starting it again.
+func (t *probeTarget) stop() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	if t.exporter == nil {
+		return nil
+	}
+
+	t.registerer.Unregister(t.exporter)
+	err := t.exporter.Close()
+	t.exporter = nil
+
+	if lerr := t.client.Logout(); lerr != nil {
+		log.Printf("could not log out of target %q: %v", t.Name, lerr)
+	}
+	return err
+}
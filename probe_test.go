@@ -0,0 +1,267 @@
+package edgemax_exporter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+func TestProber(t *testing.T) {
+	// A device which rejects any credentials.
+	var logins int32
+	d := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer d.Close()
+
+	p, err := NewProber([]Target{{
+		Name:     "router1",
+		Address:  d.URL,
+		Username: "ubnt",
+		Password: "secret",
+		Client:   d.Client(),
+	}})
+	if err != nil {
+		t.Fatalf("failed to create prober: %v", err)
+	}
+	defer p.Close()
+
+	now := time.Unix(0, 0)
+	p.targets["router1"].now = func() time.Time { return now }
+
+	var tests = []struct {
+		desc    string
+		query   string
+		elapsed time.Duration
+		status  int
+		body    string
+		logins  int32
+	}{
+		{
+			desc:   "missing target",
+			status: http.StatusBadRequest,
+			body:   "target parameter is missing",
+		},
+		{
+			desc:   "unknown target",
+			query:  "?target=router2",
+			status: http.StatusNotFound,
+			body:   `unknown target "router2"`,
+		},
+		{
+			desc:   "target failing to log in",
+			query:  "?target=router1",
+			status: http.StatusOK,
+			body:   "edgemax_login_failures_total 1",
+			logins: 1,
+		},
+		{
+			desc:    "target backing off",
+			query:   "?target=router1",
+			elapsed: minLoginBackoff / 2,
+			status:  http.StatusOK,
+			body:    "edgemax_login_failures_total 1",
+			logins:  1,
+		},
+		{
+			desc:    "target logging in again after backoff",
+			query:   "?target=router1",
+			elapsed: minLoginBackoff,
+			status:  http.StatusOK,
+			body:    "edgemax_login_failures_total 2",
+			logins:  2,
+		},
+		{
+			desc:    "target backing off longer",
+			query:   "?target=router1",
+			elapsed: minLoginBackoff,
+			status:  http.StatusOK,
+			body:    "edgemax_login_failures_total 2",
+			logins:  2,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		now = now.Add(tt.elapsed)
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe"+tt.query, nil))
+
+		if want, got := tt.status, w.Code; want != got {
+			t.Fatalf("unexpected status code:\n- want: %d\n-  got: %d", want, got)
+		}
+		body, _ := ioutil.ReadAll(w.Body)
+		if !strings.Contains(string(body), tt.body) {
+			t.Fatalf("unexpected body:\n- want: %s\n-  got: %s", tt.body, body)
+		}
+		if want, got := tt.logins, atomic.LoadInt32(&logins); want != got {
+			t.Fatalf("unexpected number of login requests:\n- want: %d\n-  got: %d", want, got)
+		}
+	}
+}
+
+func TestProberSessionEnded(t *testing.T) {
+	// A device whose password is changed once the first session is
+	// established: the session expires, and logging in again fails until
+	// the new password is known.
+	var (
+		mu     sync.Mutex
+		logins int
+	)
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		logins++
+		n := logins
+		mu.Unlock()
+
+		if n == 2 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "PHPSESSID", Value: "session"})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/api/edge/heartbeat.json", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		expired := logins == 1
+		mu.Unlock()
+
+		fmt.Fprintf(w, `{"SESSION":%t}`, !expired)
+	})
+	mux.HandleFunc("/ws/stats", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	d := httptest.NewTLSServer(mux)
+	defer d.Close()
+
+	p, err := NewProber([]Target{{
+		Name:       "router1",
+		Address:    d.URL,
+		Username:   "ubnt",
+		Password:   "secret",
+		Client:     d.Client(),
+		Collectors: []string{CollectorInterfaces},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create prober: %v", err)
+	}
+	defer p.Close()
+
+	target := p.targets["router1"]
+	now := time.Unix(0, 0)
+	target.now = func() time.Time { return now }
+
+	probe := func() string {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?target=router1", nil))
+		body, _ := ioutil.ReadAll(w.Body)
+		return string(body)
+	}
+
+	probe()
+	target.mu.Lock()
+	e := target.exporter
+	target.mu.Unlock()
+	if e == nil {
+		t.Fatal("exporter not started")
+	}
+
+	select {
+	case <-e.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for session to end")
+	}
+	if want, got := edgemax.ErrInvalidCredentials, e.Err(); !errors.Is(got, want) {
+		t.Fatalf("unexpected session error:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The ended session is discarded, and the target backs off before
+	// logging in again.
+	if body := probe(); !strings.Contains(body, "edgemax_up 0") {
+		t.Fatalf("target reported up after session ended:\n%s", body)
+	}
+	mu.Lock()
+	n := logins
+	mu.Unlock()
+	if want, got := 2, n; want != got {
+		t.Fatalf("unexpected number of login requests:\n- want: %d\n-  got: %d", want, got)
+	}
+
+	now = now.Add(minLoginBackoff)
+	if body := probe(); !strings.Contains(body, "edgemax_up 1") {
+		t.Fatalf("target not restarted after backoff:\n%s", body)
+	}
+	mu.Lock()
+	n = logins
+	mu.Unlock()
+	if want, got := 3, n; want != got {
+		t.Fatalf("unexpected number of login requests:\n- want: %d\n-  got: %d", want, got)
+	}
+}
+
+func TestProberAddRemove(t *testing.T) {
+	p, err := NewProber(nil)
+	if err != nil {
+		t.Fatalf("failed to create prober: %v", err)
+	}
+	defer p.Close()
+
+	target := Target{Name: "router1", Address: "https://192.0.2.1"}
+	if err := p.Add(target); err != nil {
+		t.Fatalf("failed to add target: %v", err)
+	}
+	if err := p.Add(target); err == nil {
+		t.Fatal("expected an error for duplicate target")
+	}
+	if want, got := []string{"router1"}, p.Targets(); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("unexpected targets:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// A failed replacement leaves the targets unchanged.
+	if err := p.Replace([]string{"router1"}, []Target{
+		{Name: "router2", Address: "https://192.0.2.2"},
+		{Name: "router2", Address: "https://192.0.2.3"},
+	}); err == nil {
+		t.Fatal("expected an error for duplicate target")
+	}
+	if want, got := []string{"router1"}, p.Targets(); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("unexpected targets:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if err := p.Remove("router1"); err != nil {
+		t.Fatalf("failed to remove target: %v", err)
+	}
+	if err := p.Remove("router1"); err == nil {
+		t.Fatal("expected an error for unknown target")
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?target=router1", nil))
+	if want, got := http.StatusNotFound, w.Code; want != got {
+		t.Fatalf("unexpected status code:\n- want: %d\n-  got: %d", want, got)
+	}
+}
@@ -0,0 +1,154 @@
+package edgemax_exporter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+// defaultSeriesTTL is the duration after which a series which is no longer
+// reported by the device is removed, unless configured with WithSeriesTTL.
+const defaultSeriesTTL = 5 * time.Minute
+
+// A seriesCache keeps the latest value of each series reported by the device,
+// along with the time it was last seen, so that series which disappear from
+// the device are removed once they are older than a TTL.
+type seriesCache struct {
+	ttl     time.Duration
+	expired prometheus.Counter
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*seriesEntry
+}
+
+// A seriesEntry is the latest value of a series.
+type seriesEntry struct {
+	labels   []string
+	value    interface{}
+	counters []deviceCounter
+	lastSeen time.Time
+}
+
+// A deviceCounter turns a counter of the device, which is reset when the
+// device reboots or its counters are cleared, into a monotonic counter.
+// valid is false when the latest update did not report the counter, which
+// is then not exported.
+type deviceCounter struct {
+	raw, offset float64
+	valid       bool
+}
+
+// next returns the state of the counter after the device reported raw. A
+// value lower than the previous one means the device reset its counter.
+func (c deviceCounter) next(raw float64) deviceCounter {
+	if raw < c.raw {
+		c.offset += c.raw
+	}
+	c.raw = raw
+	return c
+}
+
+// total returns the monotonic value of the counter.
+func (c deviceCounter) total() float64 {
+	return c.offset + c.raw
+}
+
+// newSeriesCache creates a seriesCache which counts removed series using
+// expired. A non-positive ttl only keeps the series of the latest update.
+func newSeriesCache(ttl time.Duration, expired prometheus.Counter) *seriesCache {
+	return &seriesCache{
+		ttl:     ttl,
+		expired: expired,
+		now:     time.Now,
+		entries: make(map[string]*seriesEntry),
+	}
+}
+
+// A seriesUpdate is a batch of series values received in one message.
+type seriesUpdate map[string]*seriesEntry
+
+// set adds the value of a series to the update, along with the raw values
+// of its device counters.
+func (u seriesUpdate) set(value interface{}, counters []edgemax.Uint64, labels ...string) {
+	cs := make([]deviceCounter, len(counters))
+	for i, v := range counters {
+		cs[i] = deviceCounter{raw: float64(v.Value), valid: v.Valid}
+	}
+
+	u[strings.Join(labels, "\xff")] = &seriesEntry{
+		labels:   labels,
+		value:    value,
+		counters: cs,
+	}
+}
+
+// update stores the series of an update and removes expired ones.
+func (c *seriesCache) update(u seriesUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for k, e := range u {
+		if old, ok := c.entries[k]; ok && len(old.counters) == len(e.counters) {
+			for i, dc := range e.counters {
+				// A counter missing from the update is not a reset, so
+				// its state is kept for the next update reporting it.
+				e.counters[i] = old.counters[i]
+				e.counters[i].valid = dc.valid
+				if dc.valid {
+					e.counters[i] = e.counters[i].next(dc.raw)
+				}
+			}
+		}
+
+		e.lastSeen = now
+		c.entries[k] = e
+	}
+
+	if c.ttl <= 0 {
+		for k := range c.entries {
+			if _, ok := u[k]; !ok {
+				c.remove(k)
+			}
+		}
+		return
+	}
+
+	c.prune(now)
+}
+
+// each calls fn for each series which is not expired, with its device
+// counters. Counters which are not valid must not be exported.
+func (c *seriesCache) each(fn func(value interface{}, counters []deviceCounter, labels []string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune(c.now())
+	for _, e := range c.entries {
+		fn(e.value, append([]deviceCounter(nil), e.counters...), e.labels)
+	}
+}
+
+// prune removes series which were not seen within the TTL. The caller must
+// hold mu.
+func (c *seriesCache) prune(now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	for k, e := range c.entries {
+		if now.Sub(e.lastSeen) > c.ttl {
+			c.remove(k)
+		}
+	}
+}
+
+// remove deletes a series and counts it as expired. The caller must hold mu.
+func (c *seriesCache) remove(k string) {
+	delete(c.entries, k)
+	c.expired.Inc()
+}
@@ -0,0 +1,133 @@
+package edgemax_exporter
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+func TestSeriesCache(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		ttl     time.Duration
+		updates [][]string
+		elapsed time.Duration
+		out     []string
+		expired float64
+	}{
+		{
+			desc:    "series kept within TTL",
+			ttl:     time.Minute,
+			updates: [][]string{{"eth0", "eth1"}, {"eth0"}},
+			elapsed: 30 * time.Second,
+			out:     []string{"eth0", "eth1"},
+		},
+		{
+			desc:    "series expired after TTL",
+			ttl:     time.Minute,
+			updates: [][]string{{"eth0", "eth1"}, {"eth0"}},
+			elapsed: 2 * time.Minute,
+			out:     []string{"eth0"},
+			expired: 1,
+		},
+		{
+			desc:    "zero TTL keeps latest update only",
+			updates: [][]string{{"eth0", "eth1"}, {"eth2"}},
+			out:     []string{"eth2"},
+			expired: 2,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		expired := prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"})
+		c := newSeriesCache(tt.ttl, expired)
+
+		now := time.Unix(0, 0)
+		c.now = func() time.Time { return now }
+
+		for j, names := range tt.updates {
+			if j > 0 {
+				now = now.Add(tt.elapsed)
+			}
+
+			u := make(seriesUpdate)
+			for _, n := range names {
+				u.set(j, nil, n)
+			}
+			c.update(u)
+		}
+
+		var out []string
+		c.each(func(_ interface{}, _ []deviceCounter, labels []string) {
+			out = append(out, labels[0])
+		})
+		sort.Strings(out)
+
+		if want, got := tt.out, out; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected series:\n- want: %v\n-  got: %v", want, got)
+		}
+
+		var m dto.Metric
+		if err := expired.Write(&m); err != nil {
+			t.Fatalf("failed to read counter: %v", err)
+		}
+		if want, got := tt.expired, m.GetCounter().GetValue(); want != got {
+			t.Fatalf("unexpected number of expired series:\n- want: %v\n-  got: %v", want, got)
+		}
+	}
+}
+
+func TestSeriesCacheCounterReset(t *testing.T) {
+	c := newSeriesCache(time.Minute, prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}))
+
+	var out []float64
+	for _, raw := range []uint64{10, 25, 5, 7, 0, 3} {
+		u := make(seriesUpdate)
+		u.set(nil, []edgemax.Uint64{{Value: raw, Valid: true}}, "eth0")
+		c.update(u)
+
+		c.each(func(_ interface{}, counters []deviceCounter, _ []string) {
+			out = append(out, counters[0].total())
+		})
+	}
+
+	if want, got := []float64{10, 25, 30, 32, 32, 35}, out; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected counter values:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestSeriesCacheMissingCounter(t *testing.T) {
+	c := newSeriesCache(time.Minute, prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}))
+
+	var out []string
+	for _, v := range []edgemax.Uint64{
+		{},
+		{Value: 1000, Valid: true},
+		{},
+		{Value: 1010, Valid: true},
+	} {
+		u := make(seriesUpdate)
+		u.set(nil, []edgemax.Uint64{v}, "eth0")
+		c.update(u)
+
+		c.each(func(_ interface{}, counters []deviceCounter, _ []string) {
+			if counters[0].valid {
+				out = append(out, fmt.Sprint(counters[0].total()))
+			} else {
+				out = append(out, "missing")
+			}
+		})
+	}
+
+	if want, got := []string{"missing", "1000", "missing", "1010"}, out; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected counter values:\n- want: %v\n-  got: %v", want, got)
+	}
+}
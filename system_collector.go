@@ -2,6 +2,7 @@ package edgemax_exporter
 
 import (
 	"strconv"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vaga/edgemax_exporter/edgemax"
@@ -10,9 +11,18 @@ import (
 // A systemCollector is a Prometheus collector for metrics regarding Ubiquiti
 // UniFi devices.
 type systemCollector struct {
-	cpuPercent    prometheus.Gauge
-	uptimeSeconds prometheus.Gauge
-	memoryPercent prometheus.Gauge
+	cpuPercent         *prometheus.Desc
+	cpuCorePercent     *prometheus.Desc
+	uptimeSeconds      *prometheus.Desc
+	memoryPercent      *prometheus.Desc
+	memoryBytes        *prometheus.Desc
+	temperatureCelsius *prometheus.Desc
+	deviceInfo         *prometheus.Desc
+
+	// stat holds the latest *edgemax.SystemStat received, and info the
+	// latest *edgemax.SystemInfo retrieved.
+	stat atomic.Value
+	info atomic.Value
 }
 
 // Verify that the Exporter implements the prometheus.Collector interface.
@@ -20,73 +30,124 @@ var _ prometheus.Collector = &systemCollector{}
 
 // newSystemCollector creates a new systemCollector which collects metrics for
 // a specified site.
-func newSystemCollector(ch <-chan edgemax.SystemStat) *systemCollector {
+func newSystemCollector() *systemCollector {
 	const subsystem = "system"
 
-	c := &systemCollector{
-		cpuPercent: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "cpu_percent",
-			Help:      "System CPU usage percentage",
-		}),
-		uptimeSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "uptime_seconds",
-			Help:      "System uptime in seconds",
-		}),
-		memoryPercent: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: subsystem,
-			Name:      "memory_percent",
-			Help:      "System memory usage percentage",
-		}),
+	return &systemCollector{
+		cpuPercent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cpu_percent"),
+			"System CPU usage percentage",
+			nil, nil,
+		),
+		cpuCorePercent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "cpu_core_percent"),
+			"System CPU usage percentage, partitioned by CPU core",
+			[]string{"core"}, nil,
+		),
+		uptimeSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "uptime_seconds"),
+			"System uptime in seconds",
+			nil, nil,
+		),
+		memoryPercent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "memory_percent"),
+			"System memory usage percentage",
+			nil, nil,
+		),
+		memoryBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "memory_bytes"),
+			"System memory in bytes, partitioned by type (total, free, buffers, cached)",
+			[]string{"type"}, nil,
+		),
+		temperatureCelsius: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "temperature_celsius"),
+			"Temperature in degrees Celsius, partitioned by sensor",
+			[]string{"sensor"}, nil,
+		),
+		deviceInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "device", "info"),
+			"Information about the EdgeMAX device",
+			[]string{"hostname", "model", "firmware"}, nil,
+		),
 	}
-
-	go c.collect(ch)
-
-	return c
 }
 
-// collect begins a metrics collection task for all metrics related to UniFi
-// devices.
-func (c *systemCollector) collect(ch <-chan edgemax.SystemStat) {
-	for s := range ch {
-		cpu, _ := strconv.Atoi(s.CPU)
-		c.cpuPercent.Set(float64(cpu))
-
-		uptime, _ := strconv.Atoi(s.Uptime)
-		c.uptimeSeconds.Set(float64(uptime))
+// update stores the latest system stats, which are exported on the next
+// scrape.
+func (c *systemCollector) update(s edgemax.SystemStat) {
+	c.stat.Store(&s)
+}
 
-		mem, _ := strconv.Atoi(s.Mem)
-		c.memoryPercent.Set(float64(mem))
-	}
+// updateInfo stores the latest device information, which is exported on the
+// next scrape.
+func (c *systemCollector) updateInfo(si *edgemax.SystemInfo) {
+	c.info.Store(si)
 }
 
-// metrics contains a list of metrics which are collected each time
-// the exporter is scraped. This list must be kept in sync with the metrics
-// in systemCollector.
-func (c *systemCollector) metrics() []prometheus.Metric {
-	return []prometheus.Metric{
+// Describe sends the descriptors of each metric over to the provided channel.
+// The corresponding metric values are sent separately.
+func (c *systemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ds := []*prometheus.Desc{
 		c.cpuPercent,
+		c.cpuCorePercent,
 		c.uptimeSeconds,
 		c.memoryPercent,
+		c.memoryBytes,
+		c.temperatureCelsius,
+		c.deviceInfo,
 	}
-}
 
-// Describe sends the descriptors of each metric over to the provided channel.
-// The corresponding metric values are sent separately.
-func (c *systemCollector) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range c.metrics() {
-		ch <- m.Desc()
+	for _, d := range ds {
+		ch <- d
 	}
 }
 
 // Collect sends the metric values for each metric pertaining to the global
 // cluster usage over to the provided prometheus Metric channel.
 func (c *systemCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, m := range c.metrics() {
-		ch <- m
+	if si, ok := c.info.Load().(*edgemax.SystemInfo); ok {
+		ch <- prometheus.MustNewConstMetric(c.deviceInfo, prometheus.GaugeValue, 1,
+			si.Hostname, si.Model, si.Firmware)
+	}
+
+	s, ok := c.stat.Load().(*edgemax.SystemStat)
+	if !ok {
+		return
+	}
+
+	gauge := func(d *prometheus.Desc, v edgemax.Float64, labels ...string) {
+		if v.Valid {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v.Value, labels...)
+		}
+	}
+
+	gauge(c.cpuPercent, s.CPU)
+	if s.Uptime.Valid {
+		ch <- prometheus.MustNewConstMetric(c.uptimeSeconds, prometheus.GaugeValue, float64(s.Uptime.Value))
+	}
+	gauge(c.memoryPercent, s.Mem)
+
+	for i, core := range s.CPUCores {
+		gauge(c.cpuCorePercent, core, strconv.Itoa(i))
+	}
+
+	if m := s.Memory; m != nil {
+		for _, v := range []struct {
+			typ string
+			kib edgemax.Uint64
+		}{
+			{typ: "total", kib: m.Total},
+			{typ: "free", kib: m.Free},
+			{typ: "buffers", kib: m.Buffers},
+			{typ: "cached", kib: m.Cached},
+		} {
+			if v.kib.Valid {
+				ch <- prometheus.MustNewConstMetric(c.memoryBytes, prometheus.GaugeValue, float64(v.kib.Value)*1024, v.typ)
+			}
+		}
+	}
+
+	for sensor, t := range s.Temperatures {
+		gauge(c.temperatureCelsius, t, sensor)
 	}
 }
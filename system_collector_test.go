@@ -0,0 +1,95 @@
+package edgemax_exporter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vaga/edgemax_exporter/edgemax"
+)
+
+func TestSystemCollector(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		in      string
+		info    *edgemax.SystemInfo
+		want    map[string]float64
+		missing []string
+	}{
+		{
+			desc: "full stat with device information",
+			in: `{
+				"cpu": "12", "uptime": "3600", "mem": "40",
+				"cpu_cores": ["10", "14"],
+				"memory": {"total": "1000", "free": "600", "buffers": "50", "cached": "100"},
+				"temps": {"CPU": "55.5", "PHY1": "48"}
+			}`,
+			info: &edgemax.SystemInfo{Hostname: "router1", Model: "ER-4", Firmware: "v2.0.9"},
+			want: map[string]float64{
+				`edgemax_system_cpu_percent{}`:                                           12,
+				`edgemax_system_uptime_seconds{}`:                                        3600,
+				`edgemax_system_memory_percent{}`:                                        40,
+				`edgemax_system_cpu_core_percent{core="0"}`:                              10,
+				`edgemax_system_cpu_core_percent{core="1"}`:                              14,
+				`edgemax_system_memory_bytes{type="total"}`:                              1000 * 1024,
+				`edgemax_system_memory_bytes{type="free"}`:                               600 * 1024,
+				`edgemax_system_memory_bytes{type="buffers"}`:                            50 * 1024,
+				`edgemax_system_memory_bytes{type="cached"}`:                             100 * 1024,
+				`edgemax_system_temperature_celsius{sensor="CPU"}`:                       55.5,
+				`edgemax_system_temperature_celsius{sensor="PHY1"}`:                      48,
+				`edgemax_device_info{firmware="v2.0.9",hostname="router1",model="ER-4"}`: 1,
+			},
+		},
+		{
+			desc: "stat without memory and temperatures, before device information",
+			in:   `{"cpu": "12", "uptime": "3600", "mem": "40"}`,
+			want: map[string]float64{
+				`edgemax_system_cpu_percent{}`:    12,
+				`edgemax_system_uptime_seconds{}`: 3600,
+				`edgemax_system_memory_percent{}`: 40,
+			},
+			missing: []string{
+				"edgemax_system_cpu_core_percent",
+				"edgemax_system_memory_bytes",
+				"edgemax_system_temperature_celsius",
+				"edgemax_device_info",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		var s edgemax.SystemStat
+		if err := json.Unmarshal([]byte(tt.in), &s); err != nil {
+			t.Fatalf("failed to decode system stat: %v", err)
+		}
+
+		c := newSystemCollector()
+		c.update(s)
+		if tt.info != nil {
+			c.updateInfo(tt.info)
+		}
+
+		r := prometheus.NewPedanticRegistry()
+		r.MustRegister(c)
+
+		got := gather(t, r)
+		if want, got := len(tt.want), len(got); want != got {
+			t.Fatalf("unexpected number of metrics:\n- want: %d\n-  got: %d", want, got)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Fatalf("unexpected value for %s:\n- want: %v\n-  got: %v", k, v, got[k])
+			}
+		}
+		for _, name := range tt.missing {
+			for k, v := range got {
+				if strings.HasPrefix(k, name+"{") {
+					t.Fatalf("unexpected %s for missing stat: %v", k, v)
+				}
+			}
+		}
+	}
+}
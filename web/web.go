@@ -0,0 +1,335 @@
+// Package web serves the HTTP endpoints of the exporter with optional TLS,
+// mutual TLS and basic authentication, configured by a file in the format
+// of the Prometheus exporter-toolkit.
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of a web configuration file.
+type Config struct {
+	TLSServerConfig  TLSConfig         `yaml:"tls_server_config"`
+	HTTPServerConfig HTTPConfig        `yaml:"http_server_config"`
+	Users            map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig contains the TLS settings of the server. TLS is disabled if
+// no setting is specified.
+type TLSConfig struct {
+	CertFile       string     `yaml:"cert_file"`
+	KeyFile        string     `yaml:"key_file"`
+	ClientAuthType string     `yaml:"client_auth_type"`
+	ClientCAFile   string     `yaml:"client_ca_file"`
+	MinVersion     tlsVersion `yaml:"min_version"`
+	MaxVersion     tlsVersion `yaml:"max_version"`
+}
+
+// HTTPConfig contains the HTTP settings of the server.
+type HTTPConfig struct {
+	HTTP2 bool `yaml:"http2"`
+}
+
+// errNoTLSConfig is returned by TLSConfig.config when TLS is disabled.
+var errNoTLSConfig = errors.New("TLS is not configured")
+
+// Load reads a web configuration file. Relative paths in the file are
+// resolved from its directory.
+func Load(file string) (*Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{
+		TLSServerConfig: TLSConfig{MinVersion: tls.VersionTLS12},
+		// HTTP/2 is enabled by default, as in the exporter-toolkit.
+		HTTPServerConfig: HTTPConfig{HTTP2: true},
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	dir := filepath.Dir(file)
+	t := &c.TLSServerConfig
+	for _, p := range []*string{&t.CertFile, &t.KeyFile, &t.ClientCAFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s: invalid bcrypt hash for user %q: %v", file, user, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Validate checks that a web configuration file and the certificates it
+// references can be loaded. An empty file name is valid.
+func Validate(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	c, err := Load(file)
+	if err != nil {
+		return err
+	}
+	if _, err := c.TLSServerConfig.config(); err != nil && err != errNoTLSConfig {
+		return err
+	}
+	return nil
+}
+
+// clientAuthTypes maps the client authentication policies of the
+// exporter-toolkit format to their TLS equivalents.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// config builds the TLS configuration of the server, reading its
+// certificates.
+func (t *TLSConfig) config() (*tls.Config, error) {
+	if t.CertFile == "" && t.KeyFile == "" && t.ClientCAFile == "" && t.ClientAuthType == "" {
+		return nil, errNoTLSConfig
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file are required to enable TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %v", err)
+	}
+
+	auth, ok := clientAuthTypes[t.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", t.ClientAuthType)
+	}
+	if t.ClientCAFile != "" && auth == tls.NoClientCert {
+		return nil, errors.New("client_ca_file requires a client_auth_type verifying client certificates")
+	}
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   auth,
+		MinVersion:   uint16(t.MinVersion),
+		MaxVersion:   uint16(t.MaxVersion),
+	}
+
+	if t.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", t.ClientCAFile)
+		}
+	}
+
+	return c, nil
+}
+
+// A tlsVersion is a TLS version, written as in the exporter-toolkit format.
+type tlsVersion uint16
+
+// tlsVersions maps TLS version names to their values.
+var tlsVersions = map[string]tlsVersion{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (v *tlsVersion) UnmarshalYAML(n *yaml.Node) error {
+	tv, ok := tlsVersions[n.Value]
+	if !ok {
+		return fmt.Errorf("line %d: unknown TLS version %q", n.Line, n.Value)
+	}
+	*v = tv
+	return nil
+}
+
+// ListenAndServe listens on addr and calls Serve.
+func ListenAndServe(server *http.Server, addr, file string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	return Serve(l, server, file)
+}
+
+// Serve serves requests accepted on l using server. If file is not empty,
+// it enables TLS and basic authentication according to the web
+// configuration file. The file is read again for each request and TLS
+// handshake, so that certificates and users can be changed without a
+// restart.
+func Serve(l net.Listener, server *http.Server, file string) error {
+	if file == "" {
+		return server.Serve(l)
+	}
+
+	c, err := Load(file)
+	if err != nil {
+		return err
+	}
+
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = &authHandler{
+		file:    file,
+		handler: handler,
+		cache:   make(map[[sha256.Size]byte]bool),
+	}
+
+	tc, err := c.TLSServerConfig.config()
+	switch err {
+	case nil:
+	case errNoTLSConfig:
+		return server.Serve(l)
+	default:
+		return err
+	}
+
+	// The protocols are set explicitly, as ServeTLS only adds them to its
+	// own copy of the TLS configuration, and not to the configurations
+	// reloaded for each handshake.
+	nextProtos := []string{"h2", "http/1.1"}
+	if !c.HTTPServerConfig.HTTP2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		nextProtos = []string{"http/1.1"}
+	}
+
+	server.TLSConfig = tc
+	server.TLSConfig.NextProtos = nextProtos
+	server.TLSConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c, err := Load(file)
+		if err != nil {
+			log.Printf("cannot reload web configuration: %v", err)
+			return nil, err
+		}
+		tc, err := c.TLSServerConfig.config()
+		if err != nil {
+			log.Printf("cannot reload TLS configuration: %v", err)
+			return nil, err
+		}
+		tc.NextProtos = nextProtos
+		return tc, nil
+	}
+
+	return server.ServeTLS(l, "", "")
+}
+
+// maxCachedLogins is the number of successful logins remembered by an
+// authHandler, to avoid computing a bcrypt hash on every request.
+const maxCachedLogins = 100
+
+// An authHandler requires basic authentication for the users of a web
+// configuration file, if any.
+type authHandler struct {
+	file    string
+	handler http.Handler
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]bool
+}
+
+// ServeHTTP implements http.Handler.
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := Load(h.file)
+	if err != nil {
+		log.Printf("cannot reload web configuration: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(c.Users) > 0 {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !h.authenticate(c.Users, user, pass) {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	h.handler.ServeHTTP(w, r)
+}
+
+// authenticate checks a password against the bcrypt hash of a user.
+func (h *authHandler) authenticate(users map[string]string, user, pass string) bool {
+	hash, ok := users[user]
+	if !ok {
+		// Spend the same time as for an existing user, so that users
+		// cannot be enumerated.
+		hash = unknownUserHash()
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + pass))
+
+	h.mu.Lock()
+	cached := h.cache[key]
+	h.mu.Unlock()
+	if cached {
+		return ok
+	}
+
+	valid := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	if !valid || !ok {
+		return false
+	}
+
+	h.mu.Lock()
+	if len(h.cache) >= maxCachedLogins {
+		h.cache = make(map[[sha256.Size]byte]bool)
+	}
+	h.cache[key] = true
+	h.mu.Unlock()
+
+	return true
+}
+
+var (
+	unknownUserOnce sync.Once
+	unknownUser     string
+)
+
+// unknownUserHash returns a bcrypt hash compared with the passwords of
+// unknown users.
+func unknownUserHash() string {
+	unknownUserOnce.Do(func() {
+		b, _ := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+		unknownUser = string(b)
+	})
+	return unknownUser
+}
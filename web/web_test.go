@@ -0,0 +1,272 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestServeBasicAuth(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	file := writeFile(t, dir, "web.yml", "basic_auth_users:\n  alice: "+string(hash)+"\n")
+
+	url := serve(t, file)
+
+	var tests = []struct {
+		desc   string
+		user   string
+		pass   string
+		status int
+	}{
+		{
+			desc:   "no credentials",
+			status: http.StatusUnauthorized,
+		},
+		{
+			desc:   "wrong password",
+			user:   "alice",
+			pass:   "wrong",
+			status: http.StatusUnauthorized,
+		},
+		{
+			desc:   "unknown user",
+			user:   "bob",
+			pass:   "secret",
+			status: http.StatusUnauthorized,
+		},
+		{
+			desc:   "valid credentials",
+			user:   "alice",
+			pass:   "secret",
+			status: http.StatusOK,
+		},
+		{
+			desc:   "cached credentials",
+			user:   "alice",
+			pass:   "secret",
+			status: http.StatusOK,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		req, err := http.NewRequest(http.MethodGet, "http://"+url, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+
+		if want, got := tt.status, res.StatusCode; want != got {
+			t.Fatalf("unexpected status code:\n- want: %d\n-  got: %d", want, got)
+		}
+	}
+}
+
+func TestServeTLSReload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	writeCert(t, dir, 1)
+	file := writeFile(t, dir, "web.yml", "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n")
+
+	url := serve(t, file)
+
+	for _, serial := range []int64{1, 2} {
+		writeCert(t, dir, serial)
+
+		conn, err := tls.Dial("tcp", url, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		cert := conn.ConnectionState().PeerCertificates[0]
+		conn.Close()
+
+		if want, got := serial, cert.SerialNumber.Int64(); want != got {
+			t.Fatalf("unexpected certificate serial number:\n- want: %d\n-  got: %d", want, got)
+		}
+	}
+}
+
+func TestServeHTTP2(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	writeCert(t, dir, 1)
+
+	var tests = []struct {
+		desc     string
+		http2    bool
+		protocol string
+	}{
+		{
+			desc:     "HTTP/2 enabled",
+			http2:    true,
+			protocol: "h2",
+		},
+		{
+			desc:     "HTTP/2 disabled",
+			protocol: "http/1.1",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		file := writeFile(t, dir, "web.yml", fmt.Sprintf("tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\nhttp_server_config:\n  http2: %t\n", tt.http2))
+		url := serve(t, file)
+
+		conn, err := tls.Dial("tcp", url, &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		protocol := conn.ConnectionState().NegotiatedProtocol
+		conn.Close()
+
+		if want, got := tt.protocol, protocol; want != got {
+			t.Fatalf("unexpected negotiated protocol:\n- want: %q\n-  got: %q", want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		desc string
+		in   string
+		ok   bool
+	}{
+		{
+			desc: "basic authentication",
+			in:   "basic_auth_users:\n  alice: $2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi\n",
+			ok:   true,
+		},
+		{
+			desc: "invalid hash",
+			in:   "basic_auth_users:\n  alice: secret\n",
+		},
+		{
+			desc: "unknown field",
+			in:   "tls_server_config:\n  cert: cert.pem\n",
+		},
+		{
+			desc: "missing key",
+			in:   "tls_server_config:\n  cert_file: cert.pem\n",
+		},
+		{
+			desc: "client CA without client authentication",
+			in:   "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  client_ca_file: cert.pem\n",
+		},
+		{
+			desc: "mutual TLS",
+			in:   "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  client_ca_file: cert.pem\n  client_auth_type: RequireAndVerifyClientCert\n  min_version: TLS13\n",
+			ok:   true,
+		},
+	}
+
+	writeCert(t, dir, 1)
+	for i, tt := range tests {
+		t.Logf("[%02d] test %q", i, tt.desc)
+
+		err := Validate(writeFile(t, dir, "web.yml", tt.in))
+		if want, got := tt.ok, err == nil; want != got {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// serve serves an empty page in the background according to a web
+// configuration file, and returns its address.
+func serve(t *testing.T, file string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})}
+	go Serve(l, server, file)
+
+	return l.Addr().String()
+}
+
+// tempDir creates a temporary directory.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "edgemax_exporter")
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	return dir
+}
+
+// writeFile writes a file in dir, and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return file
+}
+
+// writeCert writes a self-signed certificate with the specified serial
+// number to cert.pem and key.pem in dir.
+func writeCert(t *testing.T, dir string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	writeFile(t, dir, "cert.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, dir, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+}